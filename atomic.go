@@ -0,0 +1,105 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"os/exec"
+	"reflect"
+	"strings"
+)
+
+// the tracking configuration of a branch
+type tracking struct {
+	remote string
+	merges []string
+}
+
+// the state of the local branches before pulling
+type snapshot struct {
+	hashes   map[string]string
+	tracking map[string]tracking
+}
+
+func takeSnapshot(g *graph) (s *snapshot, err error) {
+	s = &snapshot{make(map[string]string), make(map[string]tracking)}
+	for _, n := range g.nodes {
+		if n.remote != "." {
+			continue
+		}
+		if s.hashes[n.branch], err = revParse(n.branch); err != nil {
+			return
+		}
+		if remote, merges, err := getTrackingInfo(n.branch); err == nil {
+			s.tracking[n.branch] = tracking{remote, merges}
+		}
+	}
+	return
+}
+
+// it aborts the rebase or merge in progress, resets the branches, restores the
+// tracking configuration and checks out GREB_HEAD
+func (s *snapshot) restore() (err error) {
+	logPrintf("rolling back\n")
+	setErr := func(e error) {
+		if err == nil {
+			err = e
+		}
+	}
+	if inProgress("rebase-merge") || inProgress("rebase-apply") {
+		setErr(runCommand(newCommand(!quiet, true, "git", "rebase", "--abort")))
+	}
+	if _, e := revParse("MERGE_HEAD"); e == nil {
+		setErr(runCommand(newCommand(!quiet, true, "git", "merge", "--abort")))
+	}
+	// the current branch cannot be reset safely
+	setErr(runCommand(newCommand(!quiet, true, "git", "checkout", "--detach")))
+	for branch, hash := range s.hashes {
+		if h, _ := revParse(branch); h == hash {
+			continue
+		}
+		setErr(runCommand(newCommand(!quiet, true, "git", "update-ref",
+			refsHeads+branch, hash)))
+	}
+	for branch, t := range s.tracking {
+		remote, merges, _ := getTrackingInfo(branch)
+		if remote == t.remote && reflect.DeepEqual(merges, t.merges) {
+			continue
+		}
+		// it exits with 5 if there are no values
+		e := runCommand(newCommand(!quiet, true, "git", "config", "--unset-all",
+			"branch."+branch+".merge"))
+		var ee *exec.ExitError
+		if !errors.As(e, &ee) || ee.ExitCode() != 5 {
+			setErr(e)
+		}
+		setErr(runCommand(newCommand(!quiet, true, "git", "config",
+			"branch."+branch+".remote", t.remote)))
+		for _, m := range t.merges {
+			setErr(runCommand(newCommand(!quiet, true, "git", "config", "--add",
+				"branch."+branch+".merge", m)))
+		}
+	}
+	cmd := newCommand(verbose, false, "git", "symbolic-ref", "-q", "GREB_HEAD")
+	if output, e := cmd.Output(); e == nil {
+		refname := strings.TrimSpace(string(output))
+		if verbose {
+			logPrintf("-> %s\n", refname)
+		}
+		setErr(runCommand(newCommand(!quiet, true, "git", "checkout",
+			strings.TrimPrefix(refname, refsHeads))))
+	} else {
+		setErr(runCommand(newCommand(!quiet, true, "git", "checkout", "--detach",
+			"GREB_HEAD")))
+	}
+	return
+}
+
+// whether the directory of the operation in progress exists in the git dir
+func inProgress(name string) bool {
+	path, err := gitOutput("rev-parse", "--git-path", name)
+	if err != nil {
+		return false
+	}
+	_, err = os.Stat(path)
+	return err == nil
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os/exec"
+	"strings"
+	"testing"
+)
+
+// it runs greb atomically with jobs
+func testAtomicGreb(t *testing.T, j int) error {
+	t.Helper()
+	oldatomic, oldjobs := atomic, jobs
+	t.Cleanup(func() {
+		atomic, jobs = oldatomic, oldjobs
+	})
+	atomic, jobs = true, j
+	return greb(nil)
+}
+
+func TestAtomicPullFailure(t *testing.T) {
+	for _, j := range []int{1, 2} {
+		t.Run(fmt.Sprintf("jobs=%d", j), func(t *testing.T) {
+			newTestRepository(t)
+			testGit(t, "config", "pull.rebase", "true")
+			testGit(t, "checkout", "-q", "-b", "a", "-t", "master")
+			testCommit(t, "a")
+			testGit(t, "checkout", "-q", "-b", "b", "-t", "a")
+			testCommit(t, "file")
+			testGit(t, "checkout", "-q", "master")
+			testCommit(t, "file.tmp")
+			testGit(t, "mv", "file.tmp", "file")
+			testGit(t, "commit", "-q", "--amend", "--no-edit")
+			a, b := testGit(t, "rev-parse", "a"), testGit(t, "rev-parse", "b")
+			// a is rebased before b fails
+			if err := testAtomicGreb(t, j); err == nil {
+				t.Fatal(err)
+			}
+			if h := testGit(t, "rev-parse", "a"); h != a {
+				t.Error(h)
+			}
+			if h := testGit(t, "rev-parse", "b"); h != b {
+				t.Error(h)
+			}
+			if b := testGit(t, "branch", "--show-current"); b != "master" {
+				t.Error(b)
+			}
+			if s := testGit(t, "status", "--porcelain"); s != "" {
+				t.Error(s)
+			}
+			if w := testGit(t, "worktree", "list", "--porcelain"); strings.Count(w,
+				"worktree ") != 1 {
+				t.Error(w)
+			}
+		})
+	}
+}
+
+func TestAtomicDeleteFailure(t *testing.T) {
+	for _, j := range []int{1, 2} {
+		t.Run(fmt.Sprintf("jobs=%d", j), func(t *testing.T) {
+			newTestRepository(t)
+			testGit(t, "checkout", "-q", "-b", "m2", "-t", "master")
+			testGit(t, "checkout", "-q", "-b", "m1", "-t", "m2")
+			testGit(t, "checkout", "-q", "-b", "child", "-t", "m1")
+			testCommit(t, "child")
+			m1 := testGit(t, "rev-parse", "m1")
+			oldcommand, oldremove := commandContext, remove
+			t.Cleanup(func() {
+				commandContext, remove = oldcommand, oldremove
+			})
+			// m1 is deleted and child tracks m2 before deleting m2 fails
+			commandContext = func(ctx context.Context, name string,
+				arg ...string) *exec.Cmd {
+				if strings.Join(arg, " ") == "branch -D m2" {
+					return exec.CommandContext(ctx, "false")
+				}
+				return exec.CommandContext(ctx, name, arg...)
+			}
+			remove = true
+			if err := testAtomicGreb(t, j); err == nil {
+				t.Fatal(err)
+			}
+			if h := testGit(t, "rev-parse", "m1"); h != m1 {
+				t.Error(h)
+			}
+			if r := testGit(t, "config", "branch.m1.remote"); r != "." {
+				t.Error(r)
+			}
+			if m := testGit(t, "config", "branch.m1.merge"); m != "refs/heads/m2" {
+				t.Error(m)
+			}
+			if m := testGit(t, "config", "branch.child.merge"); m != "refs/heads/m1" {
+				t.Error(m)
+			}
+			if b := testGit(t, "branch", "--show-current"); b != "child" {
+				t.Error(b)
+			}
+		})
+	}
+}
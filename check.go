@@ -0,0 +1,253 @@
+package main
+
+import (
+	"fmt"
+	"os/exec"
+	"sort"
+	"strings"
+)
+
+// it simulates the pulls of greb and prints the branches that would conflict
+func checkBranches(g *graph) (err error) {
+	// simulated tips
+	tips := make(map[*node]string)
+	tip := func(n *node) (hash string, err error) {
+		if hash = tips[n]; hash == "" {
+			hash, err = revParse(n.branch)
+		}
+		return
+	}
+	var conflicts []string
+	for _, n := range g.sort() {
+		if checkout || skip || (local && hasRemoteUpstreams(n)) {
+			continue
+		}
+		var head string
+		if head, err = tip(n); err != nil {
+			return
+		}
+		var upstreams nodesort
+		for u := range n.upstreams {
+			upstreams = append(upstreams, u)
+		}
+		sort.Sort(&upstreams)
+		var names, heads []string
+		for _, u := range upstreams {
+			var h string
+			if h, err = tip(u); err != nil {
+				return
+			}
+			names, heads = append(names, u.branch), append(heads, h)
+		}
+		action := "merge"
+		if isRebase(n) {
+			action = "rebase"
+		}
+		var result string
+		var files []string
+		if action == "rebase" {
+			result, files, err = simulateRebase(head, heads[0])
+		} else {
+			result, files, err = simulateMerge(head, heads)
+		}
+		if err != nil {
+			return
+		}
+		if len(files) > 0 {
+			conflicts = append(conflicts, n.branch)
+			fmt.Fprintf(commandOutput, "%s: %s %s: conflicts in %s\n", n.branch,
+				action, strings.Join(names, ", "), strings.Join(files, ", "))
+			continue
+		}
+		tips[n] = result
+		if result == head {
+			fmt.Fprintf(commandOutput, "%s: up to date\n", n.branch)
+		} else {
+			fmt.Fprintf(commandOutput, "%s: %s %s: ok\n", n.branch, action,
+				strings.Join(names, ", "))
+		}
+	}
+	if len(conflicts) > 0 {
+		err = fmt.Errorf("conflicts in branches: %s", strings.Join(conflicts, ", "))
+	}
+	return
+}
+
+// it decides as pullBranch and git pull whether the branch is rebased
+func isRebase(n *node) bool {
+	if rebase || interactive {
+		return true
+	} else if merge || len(n.upstreams) > 1 {
+		return false
+	}
+	for _, option := range []string{"branch." + n.branch + ".rebase",
+		"pull.rebase"} {
+		cmd := newCommand(verbose, false, "git", "config", option)
+		if output, err := cmd.CombinedOutput(); err == nil {
+			value := strings.TrimSpace(string(output))
+			if verbose {
+				logPrintf("-> %s\n", value)
+			}
+			// merges and interactive rebase too
+			b, err := configBool(option, value)
+			return err != nil || b == "true"
+		}
+		if verbose {
+			logPrintf("-> no config\n")
+		}
+	}
+	return false
+}
+
+// it merges the heads one by one into the commit, the result is a commit
+// that is not referenced by any ref
+func simulateMerge(commit string, heads []string) (result string,
+	files []string, err error) {
+	return mergeHeads(commit, heads, commitTree)
+}
+
+// it merges the heads one by one into the commit, every merge commit is created
+// by merge with the tree of the merge
+func mergeHeads(commit string, heads []string, merge func(tree string,
+	parents ...string) (string, error)) (result string, files []string,
+	err error) {
+	result = commit
+	for _, h := range heads {
+		if isAncestor(h, result) {
+			continue
+		} else if isAncestor(result, h) {
+			result = h
+			continue
+		}
+		var tree string
+		if tree, files, err = mergeTree(result, h); err != nil || len(files) > 0 {
+			return
+		}
+		if result, err = merge(tree, result, h); err != nil {
+			return
+		}
+	}
+	return
+}
+
+// it applies the commits of the branch that are not in the upstream on top of
+// it, the result is a commit that is not referenced by any ref
+func simulateRebase(commit, upstream string) (result string, files []string,
+	err error) {
+	return replayCommits(commit, upstream, func(tree, parent, c string) (
+		string, error) {
+		return commitTree(tree, parent)
+	})
+}
+
+// it applies the commits of the branch that are not in the upstream on top of
+// it, the commit applied on every parent is created by pick with the tree of
+// the cherry-pick of c
+func replayCommits(commit, upstream string, pick func(tree, parent,
+	c string) (string, error)) (result string, files []string, err error) {
+	var base string
+	if base, err = gitOutput("merge-base", "--fork-point", upstream, commit); err != nil {
+		if base, err = gitOutput("merge-base", upstream, commit); err != nil {
+			return
+		}
+	}
+	// the commits whose patches are already in the upstream are not applied, as
+	// in git rebase
+	var output string
+	if output, err = gitOutput("rev-list", "--reverse", "--no-merges",
+		"--right-only", "--cherry-pick", upstream+"..."+commit,
+		"^"+base); err != nil {
+		return
+	}
+	if isAncestor(upstream, commit) {
+		result = commit
+		return
+	}
+	result = upstream
+	for _, c := range strings.Fields(output) {
+		// a commit on top of the parent of c with the tree of result, so that the
+		// merge base of it and c is the parent of c, as in a cherry-pick
+		var tree, x string
+		if tree, err = gitOutput("rev-parse", result+"^{tree}"); err != nil {
+			return
+		}
+		if x, err = commitTree(tree, c+"^"); err != nil {
+			return
+		}
+		if tree, files, err = mergeTree(x, c); err != nil || len(files) > 0 {
+			return
+		}
+		if result, err = pick(tree, result, c); err != nil {
+			return
+		}
+	}
+	return
+}
+
+// the tree of the merge or the conflicting files
+func mergeTree(ours, theirs string) (tree string, files []string, err error) {
+	cmd := newCommand(verbose, false, "git", "merge-tree", "--write-tree",
+		"--name-only", "--no-messages", ours, theirs)
+	var output []byte
+	output, err = cmd.Output()
+	if e, ok := err.(*exec.ExitError); ok && e.ExitCode() == 1 {
+		err = nil
+		lines := strings.Split(strings.TrimSpace(string(output)), "\n")
+		found := make(map[string]struct{})
+		for _, f := range lines[1:] {
+			if _, ok := found[f]; !ok && f != "" {
+				found[f] = struct{}{}
+				files = append(files, f)
+			}
+		}
+		if verbose {
+			logPrintf("-> %s\n", strings.Join(files, ", "))
+		}
+		return
+	}
+	if err != nil {
+		err = cmdError(cmd, err)
+		return
+	}
+	tree = strings.TrimSpace(string(output))
+	if verbose {
+		logPrintf("-> %s\n", tree)
+	}
+	return
+}
+
+func commitTree(tree string, parents ...string) (commit string, err error) {
+	return commitTreeWithMessage("greb check", tree, parents...)
+}
+
+func commitTreeWithMessage(message, tree string, parents ...string) (
+	commit string, err error) {
+	args := []string{"commit-tree", tree, "-m", message}
+	for _, p := range parents {
+		args = append(args, "-p", p)
+	}
+	return gitOutput(args...)
+}
+
+func isAncestor(ancestor, commit string) bool {
+	cmd := newCommand(verbose, false, "git", "merge-base", "--is-ancestor",
+		ancestor, commit)
+	return cmd.Run() == nil
+}
+
+func gitOutput(args ...string) (output string, err error) {
+	cmd := newCommand(verbose, false, "git", args...)
+	var o []byte
+	if o, err = cmd.Output(); err != nil {
+		err = cmdError(cmd, err)
+		if verbose {
+			logPrintf("-> %s\n", err)
+		}
+		return
+	}
+	output = strings.TrimSpace(string(o))
+	if verbose {
+		logPrintf("-> %s\n", output)
+	}
+	return
+}
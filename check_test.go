@@ -0,0 +1,111 @@
+package main
+
+import "testing"
+
+func TestIsRebase(t *testing.T) {
+	newTestRepository(t)
+	n := &node{ref: ref{"refs/heads/master", "."}, branch: "master"}
+	for value, expected := range map[string]bool{"true": true, "false": false,
+		"no": false, "off": false, "0": false, "yes": true, "merges": true,
+		"interactive": true} {
+		testGit(t, "config", "pull.rebase", value)
+		if r := isRebase(n); r != expected {
+			t.Error(value, r)
+		}
+	}
+	testGit(t, "config", "branch.master.rebase", "False")
+	if r := isRebase(n); r {
+		t.Error(r)
+	}
+}
+
+func TestSimulateRebase(t *testing.T) {
+	newTestRepository(t)
+	testGit(t, "checkout", "-q", "-b", "topic")
+	testCommit(t, "topic")
+	testGit(t, "checkout", "-q", "master")
+	testCommit(t, "other")
+	head := testGit(t, "rev-parse", "topic")
+	upstream := testGit(t, "rev-parse", "master")
+	result, files, err := simulateRebase(head, upstream)
+	if err != nil || len(files) > 0 {
+		t.Fatal(files, err)
+	}
+	if p := testGit(t, "rev-parse", result+"^"); p != upstream {
+		t.Error(p)
+	}
+	testGit(t, "cat-file", "-e", result+":topic")
+	testGit(t, "cat-file", "-e", result+":other")
+	// the branches are not touched
+	if h := testGit(t, "rev-parse", "topic"); h != head {
+		t.Error(h)
+	}
+	// up to date
+	if result, files, err = simulateRebase(upstream, upstream); err != nil ||
+		len(files) > 0 || result != upstream {
+		t.Error(result, files, err)
+	}
+}
+
+func TestSimulateRebaseConflict(t *testing.T) {
+	newTestRepository(t)
+	testGit(t, "checkout", "-q", "-b", "topic")
+	testCommit(t, "file")
+	testGit(t, "checkout", "-q", "master")
+	testCommit(t, "other")
+	testCommit(t, "file.tmp")
+	testGit(t, "mv", "file.tmp", "file")
+	testGit(t, "commit", "-q", "--amend", "--no-edit")
+	_, files, err := simulateRebase(testGit(t, "rev-parse", "topic"),
+		testGit(t, "rev-parse", "master"))
+	if err != nil || len(files) != 1 || files[0] != "file" {
+		t.Error(files, err)
+	}
+}
+
+func TestCheckBranches(t *testing.T) {
+	newTestRepository(t)
+	testGit(t, "checkout", "-q", "-b", "clean", "-t", "master")
+	testCommit(t, "clean")
+	testGit(t, "checkout", "-q", "-b", "conflict", "-t", "master")
+	testCommit(t, "file")
+	testGit(t, "checkout", "-q", "master")
+	testCommit(t, "file.tmp")
+	testGit(t, "mv", "file.tmp", "file")
+	testGit(t, "commit", "-q", "--amend", "--no-edit")
+	head := testGit(t, "rev-parse", "conflict")
+	defer func() {
+		check = false
+	}()
+	check = true
+	err := greb(nil)
+	if err == nil || err.Error() != "conflicts in branches: conflict" {
+		t.Error(err)
+	}
+	if h := testGit(t, "rev-parse", "conflict"); h != head {
+		t.Error(h)
+	}
+	if b := testGit(t, "branch", "--show-current"); b != "master" {
+		t.Error(b)
+	}
+}
+
+func TestSimulateRebaseCherryPicked(t *testing.T) {
+	newTestRepository(t)
+	testGit(t, "checkout", "-q", "-b", "topic")
+	testCommit(t, "picked")
+	testCommit(t, "topic")
+	testGit(t, "checkout", "-q", "master")
+	testCommit(t, "other")
+	testGit(t, "cherry-pick", "topic^")
+	upstream := testGit(t, "rev-parse", "master")
+	result, files, err := simulateRebase(testGit(t, "rev-parse", "topic"),
+		upstream)
+	if err != nil || len(files) > 0 {
+		t.Fatal(files, err)
+	}
+	// only topic is applied
+	if p := testGit(t, "rev-parse", result+"^"); p != upstream {
+		t.Error(p)
+	}
+}
@@ -0,0 +1,423 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// the long names of the single letter options, both set the same variable
+var longOptions = [][2]string{
+	{"text", "t"},
+	{"xlib", "x"},
+	{"change", "C"},
+	{"rebase", "r"},
+	{"merge", "m"},
+	{"interactive", "i"},
+	{"checkout", "c"},
+	{"skip", "s"},
+	{"delete", "d"},
+	{"local", "l"},
+	{"quiet", "q"},
+	{"verbose", "v"},
+	{"dry-run", "n"},
+	{"jobs", "j"},
+}
+
+// the subcommands that parse the options of greb, the options of git config
+// are read after them
+var optionCommands = map[string]struct{}{
+	"graph": {}, "update": {}, "delete": {}, "status": {}, "export": {},
+}
+
+// the subcommands that print their own help with -h
+var helpCommands = map[string]struct{}{
+	"graph": {}, "update": {}, "delete": {}, "status": {}, "export": {},
+	"completion": {}, "move": {}, "split": {}, "watch": {},
+}
+
+// the subcommands of the options, they are not in the literal of subcommands
+// because completion and help look them up
+func init() {
+	subcommands["graph"] = graphCommand
+	subcommands["update"] = updateCommand
+	subcommands["delete"] = deleteCommand
+	subcommands["status"] = statusCommand
+	subcommands["export"] = exportCommand
+	subcommands["completion"] = completionCommand
+	subcommands["help"] = helpCommand
+}
+
+// it defines the long names of the single letter options in the command line
+func initLongOptions() {
+	for _, o := range longOptions {
+		f := flag.Lookup(o[1])
+		flag.Var(f.Value, o[0], "the same as -"+o[1])
+	}
+}
+
+// the single letter options and their long names, one in every line
+func formatLongOptions() string {
+	var lines []string
+	for _, o := range longOptions {
+		lines = append(lines, fmt.Sprintf("  -%s, --%s", o[1], o[0]))
+	}
+	return strings.Join(lines, "\n")
+}
+
+func isBoolFlag(f *flag.Flag) bool {
+	b, ok := f.Value.(interface{ IsBoolFlag() bool })
+	return ok && b.IsBoolFlag()
+}
+
+// it expands the groups of single letter options up to the first argument, i.e.
+// -qv into -q -v and -j4 into -j 4, as getopt does
+func expandOptions(fs *flag.FlagSet, args []string) (expanded []string) {
+	for i := 0; i < len(args); i++ {
+		a := args[i]
+		if a == "--" || len(a) < 2 || a[0] != '-' {
+			return append(expanded, args[i:]...)
+		}
+		name := strings.TrimLeft(a, "-")
+		if strings.Contains(name, "=") {
+			expanded = append(expanded, a)
+			continue
+		}
+		f := fs.Lookup(name)
+		if f == nil && a[1] != '-' {
+			if group, next := expandGroup(fs, name); group != nil {
+				expanded = append(expanded, group...)
+				if next && i+1 < len(args) {
+					i++
+					expanded = append(expanded, args[i])
+				}
+				continue
+			}
+		}
+		expanded = append(expanded, a)
+		// the value in the next argument may start with -
+		if f != nil && !isBoolFlag(f) && i+1 < len(args) {
+			i++
+			expanded = append(expanded, args[i])
+		}
+	}
+	return
+}
+
+// the options of a group of single letters, nil if it is not a group, the last
+// one may take the rest of the group as value or the next argument if next
+func expandGroup(fs *flag.FlagSet, group string) (options []string, next bool) {
+	for i, c := range group {
+		f := fs.Lookup(string(c))
+		if f == nil {
+			return nil, false
+		}
+		options = append(options, "-"+string(c))
+		if !isBoolFlag(f) {
+			if rest := group[i+1:]; rest != "" {
+				options = append(options, rest)
+			} else {
+				next = true
+			}
+			return
+		}
+	}
+	return
+}
+
+// the flag set of a subcommand with its help
+func newCommandFlagSet(name, synopsis, description string) (fs *flag.FlagSet) {
+	fs = flag.NewFlagSet(name, flag.ContinueOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage of %s %s %s:\n\n%s\n\nOptions:\n\n",
+			filepath.Base(os.Args[0]), name, synopsis, description)
+		fs.PrintDefaults()
+	}
+	return
+}
+
+// it defines the options of the command line in the subcommand with the same
+// variables, the long names are defined with their single letter aliases
+func defineOptions(fs *flag.FlagSet, names ...string) {
+	for _, name := range names {
+		f := flag.Lookup(name)
+		for _, o := range longOptions {
+			if o[0] == name {
+				short := flag.Lookup(o[1])
+				fs.Var(short.Value, name, short.Usage)
+				fs.Var(short.Value, o[1], "the same as --"+name)
+			}
+		}
+		if fs.Lookup(name) == nil {
+			fs.Var(f.Value, name, f.Usage)
+		}
+	}
+}
+
+// the flag set of the subcommand that runs greb, nil with the options of the
+// command line, greb runs in other repositories with the same subcommand
+var command *flag.FlagSet
+
+// the options of the command line that select what greb does, the subcommands
+// select it instead
+var modeFlags = append([]string{"push", "check"}, exclusiveOptions...)
+
+// it parses the options of the subcommand and completes them with the ones of
+// git config that the subcommand has
+func parseCommand(fs *flag.FlagSet, args []string) (rest []string, err error) {
+	if rest, err = parseSubcommand(fs, args); err != nil {
+		return
+	}
+	flag.Visit(func(f *flag.Flag) {
+		if err == nil && isOneOf(f.Name, modeFlags) && fs.Lookup(f.Name) == nil {
+			err = fmt.Errorf("-%s is not an option of %s", f.Name, fs.Name())
+		}
+	})
+	if err != nil {
+		return
+	}
+	command = fs
+	err = initOptions(fs)
+	return
+}
+
+// it fails if more than one of the options of the subcommand is set
+func assertExclusive(fs *flag.FlagSet, names ...string) (err error) {
+	var set []string
+	for _, name := range names {
+		if f := fs.Lookup(name); f != nil && f.Value.String() == "true" {
+			set = append(set, "--"+name)
+		}
+	}
+	if len(set) > 1 {
+		err = fmt.Errorf("incompatible options: %s", strings.Join(set, ", "))
+	}
+	return
+}
+
+// the options of all subcommands that run greb
+var commonOptions = []string{"quiet", "verbose", "dry-run", "profile"}
+
+// the options that run greb in other repositories
+var repositoryOptions = []string{"workspace", "keep-going", "jobs",
+	"recurse-submodules"}
+
+// it checks the options that run greb in other repositories, the command line
+// and the subcommands check them here
+func assertRepositoryOptions() (err error) {
+	if jobs < 1 {
+		err = fmt.Errorf("invalid number of jobs: %d", jobs)
+	} else if jobs > 1 && interactive {
+		err = fmt.Errorf("incompatible options: --jobs, --interactive")
+	} else if jobs > 1 && checkout {
+		err = fmt.Errorf("incompatible options: --jobs, --checkout")
+	}
+	return
+}
+
+func graphCommand(args []string) (err error) {
+	var format string
+	fs := newCommandFlagSet("graph", "[<options>] [<branches>]",
+		"It prints the graph of the branches in the terminal: a custom text\n"+
+			"format, as git log --graph or drawn in an xlib window.")
+	fs.StringVar(&format, "format", "text", "text, log or xlib")
+	defineOptions(fs, "verbose")
+	if args, err = parseCommand(fs, args); err != nil {
+		return
+	}
+	switch format {
+	case "text":
+		graphtxt = true
+	case "log":
+		graphlog = true
+	case "xlib":
+		graphxlib = true
+	default:
+		err = fmt.Errorf("invalid format: %s", format)
+		return
+	}
+	return runGreb(args)
+}
+
+func updateCommand(args []string) (err error) {
+	fs := newCommandFlagSet("update", "[<options>] [<branches>]",
+		"It pulls the branches in order from the upstreams to the downstreams\n"+
+			"and returns to the current branch.")
+	defineOptions(fs, "rebase", "merge", "interactive", "checkout", "delete",
+		"local", "exclude", "change", "push", "atomic", "resume")
+	defineOptions(fs, repositoryOptions...)
+	defineOptions(fs, "commit-submodules")
+	defineOptions(fs, commonOptions...)
+	if args, err = parseCommand(fs, args); err != nil {
+		return
+	}
+	if err = assertExclusive(fs, "rebase", "merge", "interactive",
+		"checkout"); err != nil {
+		return
+	}
+	if err = assertRepositoryOptions(); err != nil {
+		return
+	}
+	if commitSubmodules && !recurseSubmodules {
+		err = fmt.Errorf("--commit-submodules requires --recurse-submodules")
+		return
+	}
+	return runGreb(args)
+}
+
+func deleteCommand(args []string) (err error) {
+	var gone bool
+	fs := newCommandFlagSet("delete", "[<options>] [<branches>]",
+		"It deletes the branches that are merged into their upstream branches\n"+
+			"without pulling them.")
+	fs.BoolVar(&gone, "gone", false,
+		"it deletes the merged branches whose upstreams are gone instead")
+	defineOptions(fs, "local", "exclude", "change", "atomic", "workspace",
+		"keep-going", "recurse-submodules")
+	defineOptions(fs, commonOptions...)
+	if args, err = parseCommand(fs, args); err != nil {
+		return
+	}
+	if err = assertRepositoryOptions(); err != nil {
+		return
+	}
+	if gone {
+		pruneGone = true
+	} else {
+		skip, remove = true, true
+	}
+	return runGreb(args)
+}
+
+func statusCommand(args []string) (err error) {
+	fs := newCommandFlagSet("status", "[<options>] [<branches>]",
+		"It reports the branches that are up to date and the ones that would\n"+
+			"conflict if they were pulled, without touching them.")
+	defineOptions(fs, "rebase", "merge", "local", "exclude", "workspace",
+		"keep-going", "recurse-submodules")
+	defineOptions(fs, commonOptions...)
+	if args, err = parseCommand(fs, args); err != nil {
+		return
+	}
+	if err = assertExclusive(fs, "rebase", "merge"); err != nil {
+		return
+	}
+	if err = assertRepositoryOptions(); err != nil {
+		return
+	}
+	check = true
+	return runGreb(args)
+}
+
+func exportCommand(args []string) (err error) {
+	var format, output string
+	fs := newCommandFlagSet("export", "[<options>] [<branches>]",
+		"It writes the graph of the branches in the standard output or in the\n"+
+			"file. The svg, png and html formats need the file.")
+	fs.StringVar(&format, "format", "dot",
+		"dot, mermaid, plantuml, svg, png or html")
+	fs.StringVar(&output, "output", "", "the file to write")
+	fs.StringVar(&output, "o", "", "the same as --output")
+	defineOptions(fs, "verbose")
+	if args, err = parseCommand(fs, args); err != nil {
+		return
+	}
+	switch format {
+	case "dot":
+		graphdot = true
+	case "mermaid":
+		mermaid = true
+	case "plantuml":
+		plantuml = true
+	case "svg", "png", "html":
+		if output == "" {
+			err = fmt.Errorf("missing file to export")
+			return
+		}
+		render = format
+		return runGreb(append([]string{output}, args...))
+	default:
+		err = fmt.Errorf("invalid format: %s", format)
+		return
+	}
+	if output != "" {
+		var f *os.File
+		if f, err = os.Create(output); err != nil {
+			return
+		}
+		defer func() {
+			graphOutput = os.Stdout
+			if e := f.Close(); err == nil {
+				err = e
+			}
+		}()
+		graphOutput = f
+	}
+	return runGreb(args)
+}
+
+func completionCommand(args []string) (err error) {
+	var git bool
+	fs := newCommandFlagSet("completion", "[<options>] <shell> [<name>]",
+		"It prints the completion for bash, zsh or fish. The name is the one of\n"+
+			"the function for bash and zsh and the command for fish.")
+	fs.BoolVar(&git, "git", false,
+		"it prints the function for the completion of git instead")
+	if args, err = parseSubcommand(fs, args); err != nil {
+		return
+	}
+	if len(args) < 1 || len(args) > 2 || (git && len(args) != 1) {
+		err = fmt.Errorf("usage: completion [-git] <shell> [<name>]")
+		return
+	}
+	name := filepath.Base(os.Args[0])
+	if args[0] != "fish" {
+		name = "_" + name
+	}
+	if len(args) == 2 {
+		name = args[1]
+	}
+	if git {
+		gitComplete = args[0]
+		return printCompletion()
+	}
+	switch args[0] {
+	case "bash":
+		bash = name
+	case "zsh":
+		zsh = name
+	case "fish":
+		fish = name
+	default:
+		err = fmt.Errorf("invalid shell: %s", args[0])
+		return
+	}
+	return printCompletion()
+}
+
+func helpCommand(args []string) (err error) {
+	if len(args) > 1 {
+		err = fmt.Errorf("usage: help [<subcommand>]")
+		return
+	}
+	if len(args) == 0 {
+		flag.Usage()
+		return
+	}
+	c, ok := subcommands[args[0]]
+	if !ok {
+		err = fmt.Errorf("unknown subcommand: %s", args[0])
+		return
+	}
+	if _, ok := helpCommands[args[0]]; !ok {
+		flag.Usage()
+		return
+	}
+	if err = c([]string{"-h"}); errors.Is(err, flag.ErrHelp) {
+		err = nil
+	}
+	return
+}
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestExpandOptions(t *testing.T) {
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	fs.Bool("q", false, "")
+	fs.Bool("v", false, "")
+	fs.Bool("render", false, "")
+	fs.Int("j", 1, "")
+	fs.String("C", "", "")
+	for _, test := range []struct {
+		args, expanded []string
+	}{
+		{[]string{"-qv", "a"}, []string{"-q", "-v", "a"}},
+		{[]string{"-qj4"}, []string{"-q", "-j", "4"}},
+		{[]string{"-qC", "-v", "-v"}, []string{"-q", "-C", "-v", "-v"}},
+		{[]string{"-C", "-qv"}, []string{"-C", "-qv"}},
+		{[]string{"-render", "--qv", "-qx"}, []string{"-render", "--qv", "-qx"}},
+		{[]string{"-j=2", "a", "-qv"}, []string{"-j=2", "a", "-qv"}},
+		{[]string{"--", "-qv"}, []string{"--", "-qv"}},
+	} {
+		if e := expandOptions(fs, test.args); !reflect.DeepEqual(e,
+			test.expanded) {
+			t.Error(test.args, e)
+		}
+	}
+}
+
+func TestDefineOptions(t *testing.T) {
+	r := rebase
+	defer func() {
+		rebase = r
+	}()
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	defineOptions(fs, "rebase", "push")
+	for _, name := range []string{"rebase", "r", "push"} {
+		if fs.Lookup(name) == nil {
+			t.Error(name)
+		}
+	}
+	rebase = false
+	if args, err := parseSubcommand(fs, []string{"a", "--rebase"}); err != nil ||
+		!reflect.DeepEqual(args, []string{"a"}) || !rebase {
+		t.Error(args, err, rebase)
+	}
+	if _, err := parseSubcommand(fs, []string{"-m"}); err == nil {
+		t.Error(err)
+	}
+	// the arguments after -- are not options
+	rebase = false
+	if args, err := parseSubcommand(fs, []string{"a", "--", "--rebase",
+		"b"}); err != nil || !reflect.DeepEqual(args,
+		[]string{"a", "--rebase", "b"}) || rebase {
+		t.Error(args, err, rebase)
+	}
+	var onto string
+	fs.StringVar(&onto, "onto", "", "")
+	if args, err := parseSubcommand(fs, []string{"-onto", "--", "a",
+		"--rebase"}); err != nil || !reflect.DeepEqual(args, []string{"a"}) ||
+		onto != "--" || !rebase {
+		t.Error(args, err, onto, rebase)
+	}
+}
+
+func TestAssertExclusive(t *testing.T) {
+	var a, b, c bool
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	fs.BoolVar(&a, "a", false, "")
+	fs.BoolVar(&b, "b", false, "")
+	fs.BoolVar(&c, "c", false, "")
+	if err := assertExclusive(fs, "a", "b", "d"); err != nil {
+		t.Error(err)
+	}
+	a, c = true, true
+	if err := assertExclusive(fs, "a", "b"); err != nil {
+		t.Error(err)
+	}
+	b = true
+	err := assertExclusive(fs, "a", "b")
+	if err == nil || err.Error() != "incompatible options: --a, --b" {
+		t.Error(err)
+	}
+}
+
+// it restores what the subcommands set
+func restoreCommand(t *testing.T) {
+	oldcommand, oldoutput := command, graphOutput
+	oldlog, oldmermaid, oldrebase := graphlog, mermaid, rebase
+	t.Cleanup(func() {
+		command, graphOutput = oldcommand, oldoutput
+		graphlog, mermaid, rebase = oldlog, oldmermaid, oldrebase
+	})
+}
+
+func TestGraphCommand(t *testing.T) {
+	newTestRepository(t)
+	restoreCommand(t)
+	// it is not an option of graph
+	testGit(t, "config", "greb.rebase", "true")
+	b := new(bytes.Buffer)
+	graphOutput = b
+	if err := graphCommand([]string{"--format=log"}); err != nil {
+		t.Fatal(err)
+	}
+	if rebase || !graphlog || !strings.Contains(b.String(), "master") {
+		t.Error(rebase, graphlog, b.String())
+	}
+	if args := childArgs([]string{"a"}); !reflect.DeepEqual(args[len(args)-4:],
+		[]string{"graph", "-format=log", "--", "a"}) {
+		t.Error(args)
+	}
+	if err := graphCommand([]string{"--format=dot"}); err == nil {
+		t.Error(err)
+	}
+}
+
+func TestExportCommand(t *testing.T) {
+	newTestRepository(t)
+	restoreCommand(t)
+	file := filepath.Join(t.TempDir(), "graph.mmd")
+	if err := exportCommand([]string{"--format", "mermaid", "-o",
+		file}); err != nil {
+		t.Fatal(err)
+	}
+	if b, _ := os.ReadFile(file); !strings.HasPrefix(string(b), "flowchart") {
+		t.Error(string(b))
+	}
+	if graphOutput != os.Stdout {
+		t.Error(graphOutput)
+	}
+}
+
+func TestUpdateCommand(t *testing.T) {
+	newTestRepository(t)
+	restoreCommand(t)
+	r, m, j := rebase, merge, jobs
+	defer func() {
+		rebase, merge, jobs = r, m, j
+	}()
+	for _, args := range [][]string{{"-r", "-m"}, {"-j0"}} {
+		if err := updateCommand(args); err == nil {
+			t.Error(args)
+		}
+		rebase, merge, jobs = r, m, j
+	}
+}
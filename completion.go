@@ -0,0 +1,274 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// the values of the options that take a branch, a file or one of a few words,
+// the rest of the options that are not bool do not complete anything
+var (
+	branchOptions = map[string]struct{}{"C": {}, "change": {}, "onto": {}}
+	fileOptions   = map[string]struct{}{"workspace": {}, "output": {}, "o": {}}
+	valueOptions  = map[string][]string{
+		"render":         {"svg", "png", "html"},
+		"events":         {"json"},
+		"git-completion": {"bash", "zsh"},
+	}
+	// the values of the options of the subcommands that are not the ones of the
+	// option of the command line with the same name
+	commandValueOptions = map[string]map[string][]string{
+		"graph":  {"format": {"text", "log", "xlib"}},
+		"export": {"format": {"dot", "mermaid", "plantuml", "svg", "png", "html"}},
+	}
+)
+
+const branchesCommand = "git for-each-ref refs/heads --format '%(refname:short)'"
+
+// an option of greb for the completion
+type completionFlag struct {
+	name   string
+	usage  string
+	isBool bool
+	branch bool
+	file   bool
+	values []string
+}
+
+// the options of the flag set of the subcommand, the ones of the command line
+// without a subcommand, in alphabetical order
+func completionFlags(command string, fs *flag.FlagSet) (flags []completionFlag) {
+	if fs == nil {
+		fs = flag.CommandLine
+	}
+	fs.VisitAll(func(f *flag.Flag) {
+		c := completionFlag{name: f.Name, usage: f.Usage, isBool: isBoolFlag(f)}
+		_, c.branch = branchOptions[f.Name]
+		_, c.file = fileOptions[f.Name]
+		var ok bool
+		if c.values, ok = commandValueOptions[command][f.Name]; !ok {
+			c.values = valueOptions[f.Name]
+		}
+		flags = append(flags, c)
+	})
+	return
+}
+
+func completionSubcommands() (names []string) {
+	for name := range subcommands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return
+}
+
+// the subcommands return their flag set to it instead of parsing the arguments
+// if it is not nil
+var collectFlagSet func(fs *flag.FlagSet)
+
+// the flag sets of the subcommands that have options, the subcommands return as
+// soon as they have defined them
+func completionFlagSets() (names []string, sets map[string]*flag.FlagSet) {
+	sets = make(map[string]*flag.FlagSet)
+	defer func() {
+		collectFlagSet = nil
+	}()
+	for _, name := range completionSubcommands() {
+		if _, ok := helpCommands[name]; !ok {
+			continue
+		}
+		collectFlagSet = func(fs *flag.FlagSet) {
+			sets[name] = fs
+		}
+		subcommands[name](nil)
+		names = append(names, name)
+	}
+	return
+}
+
+// the branches are completed for the arguments and the options that take them
+func bashCompletion(funcname string) string {
+	prelude := fmt.Sprintf(`	local cur=${COMP_WORDS[COMP_CWORD]}
+	local prev=${COMP_WORDS[COMP_CWORD-1]}
+	local branches=$(%s 2>/dev/null)
+`, branchesCommand)
+	return bashFunction(funcname, prelude,
+		`COMPREPLY=( $(compgen -W "%s" -- "$cur") )`, "$branches")
+}
+
+// the function for the completion of git, cur and prev are set by git and the
+// words are completed with its functions
+func gitBashCompletion(funcname string) string {
+	return bashFunction(funcname, "", `__gitcomp "%s"`, "$(__git_heads)")
+}
+
+// reply is the command that completes the words given in %s, branches is the
+// expression of the local branches, the options of a subcommand are completed
+// once it is in the line
+func bashFunction(funcname, prelude, reply, branches string) string {
+	var s strings.Builder
+	fmt.Fprintf(&s, "%s() {\n%s", funcname, prelude)
+	names, sets := completionFlagSets()
+	fmt.Fprintf(&s, "\tlocal word subcommand\n"+
+		"\tfor word in \"${COMP_WORDS[@]:1:COMP_CWORD-1}\"; do\n"+
+		"\t\tcase $word in\n\t\t\t%s)\n\t\t\t\tsubcommand=$word\n"+
+		"\t\t\t\tbreak\n\t\t\t\t;;\n\t\tesac\n\tdone\n\tcase $subcommand in\n",
+		strings.Join(names, "|"))
+	for _, name := range names {
+		cases := bashCases(completionFlags(name, sets[name]), reply, branches,
+			branches)
+		fmt.Fprintf(&s, "\t\t%s)\n%s\t\t\treturn\n\t\t\t;;\n", name,
+			indentLines(cases, "\t\t"))
+	}
+	s.WriteString("\tesac\n")
+	s.WriteString(bashCases(completionFlags("", nil), reply, branches,
+		strings.Join(completionSubcommands(), " ")+" "+branches))
+	s.WriteString("}")
+	return s.String()
+}
+
+// the cases of the previous word, for the values of the options, and of the
+// current one, for the options and the words of the arguments
+func bashCases(flags []completionFlag, reply, branches, words string) string {
+	var s strings.Builder
+	s.WriteString("\tcase $prev in\n")
+	var short, long, none []string
+	for _, f := range flags {
+		short, long = append(short, "-"+f.name), append(long, "--"+f.name)
+		pattern := fmt.Sprintf("-%[1]s|--%[1]s", f.name)
+		if f.branch {
+			fmt.Fprintf(&s, "\t\t%s)\n\t\t\t"+reply+"\n\t\t\treturn\n\t\t\t;;\n",
+				pattern, branches)
+		} else if f.file {
+			fmt.Fprintf(&s, "\t\t%s)\n\t\t\tCOMPREPLY=( $(compgen -f -- \"$cur\") )"+
+				"\n\t\t\treturn\n\t\t\t;;\n", pattern)
+		} else if f.values != nil {
+			fmt.Fprintf(&s, "\t\t%s)\n\t\t\t"+reply+"\n\t\t\treturn\n\t\t\t;;\n",
+				pattern, strings.Join(f.values, " "))
+		} else if !f.isBool {
+			none = append(none, pattern)
+		}
+	}
+	if len(none) > 0 {
+		fmt.Fprintf(&s, "\t\t%s)\n\t\t\tCOMPREPLY=()\n\t\t\treturn\n\t\t\t;;\n",
+			strings.Join(none, "|"))
+	}
+	fmt.Fprintf(&s, "\tesac\n\tcase $cur in\n\t\t--*)\n\t\t\t"+reply+
+		"\n\t\t\t;;\n\t\t-*)\n\t\t\t"+reply+"\n\t\t\t;;\n\t\t*)\n\t\t\t"+
+		reply+"\n\t\t\t;;\n\tesac\n", strings.Join(long, " "),
+		strings.Join(short, " "), words)
+	return s.String()
+}
+
+// every line of s with the prefix
+func indentLines(s, prefix string) string {
+	lines := strings.SplitAfter(s, "\n")
+	for i, l := range lines {
+		if l != "" {
+			lines[i] = prefix + l
+		}
+	}
+	return strings.Join(lines, "")
+}
+
+// the zsh function for compdef, i.e. compdef _greb git-greb, the options of a
+// subcommand are completed once it is in the line
+func zshCompletion(funcname string) string {
+	var s strings.Builder
+	fmt.Fprintf(&s, `%[1]s_branches() {
+	local -a branches
+	branches=(${(f)"$(%[2]s 2>/dev/null)"})
+	_describe -t branches branch branches
+}
+
+%[1]s_arguments() {
+	local -a subcommands
+	subcommands=(%[3]s)
+	_describe -t subcommands subcommand subcommands
+	%[1]s_branches
+}
+
+%[1]s() {
+	local word
+	for word in ${words[2,CURRENT-1]}; do
+		case $word in
+`, funcname, branchesCommand, strings.Join(completionSubcommands(), " "))
+	names, sets := completionFlagSets()
+	for _, name := range names {
+		fmt.Fprintf(&s, "\t\t\t%s)\n\t\t\t\t_arguments \\\n%s", name,
+			indentLines(zshArguments(completionFlags(name, sets[name]), funcname),
+				"\t\t\t"))
+		fmt.Fprintf(&s, "\t\t\t\t\t'*:branch:%s_branches'\n\t\t\t\treturn\n"+
+			"\t\t\t\t;;\n", funcname)
+	}
+	fmt.Fprintf(&s, "\t\tesac\n\tdone\n\t_arguments \\\n%s",
+		zshArguments(completionFlags("", nil), funcname))
+	fmt.Fprintf(&s, "\t\t'*:branch:%s_arguments'\n}", funcname)
+	return s.String()
+}
+
+// the specs of _arguments for the options, one in every line
+func zshArguments(flags []completionFlag, funcname string) string {
+	escape := strings.NewReplacer(`\`, `\\`, "[", `\[`, "]", `\]`, ":", `\:`,
+		"'", `'\''`)
+	var s strings.Builder
+	for _, f := range flags {
+		var action string
+		if f.branch {
+			action = ":branch:" + funcname + "_branches"
+		} else if f.file {
+			action = ":file:_files"
+		} else if f.values != nil {
+			action = fmt.Sprintf(":%s:(%s)", f.name, strings.Join(f.values, " "))
+		} else if !f.isBool {
+			action = fmt.Sprintf(":%s: ", f.name)
+		}
+		fmt.Fprintf(&s, "\t\t{-%[1]s,--%[1]s}'[%[2]s]%[3]s' \\\n", f.name,
+			escape.Replace(f.usage), action)
+	}
+	return s.String()
+}
+
+// the complete commands of fish for the command, the options of a subcommand
+// are completed once it is in the line
+func fishCompletion(command string) string {
+	function := "__" + strings.NewReplacer("-", "_", ".", "_").Replace(command) +
+		"_branches"
+	var s strings.Builder
+	fmt.Fprintf(&s, "function %s\n\t%s 2>/dev/null\nend\n", function,
+		branchesCommand)
+	fmt.Fprintf(&s, "complete -c %s -f\n", command)
+	fishOptions(&s, "complete -c "+command, completionFlags("", nil), function)
+	names, sets := completionFlagSets()
+	for _, name := range names {
+		fishOptions(&s, fmt.Sprintf("complete -c %s -n '__fish_seen_subcommand_from "+
+			"%s'", command, name), completionFlags(name, sets[name]), function)
+	}
+	subs := strings.Join(completionSubcommands(), " ")
+	fmt.Fprintf(&s, "complete -c %s -n 'not __fish_seen_subcommand_from %s' "+
+		"-a '%s' -d subcommand\n", command, subs, subs)
+	fmt.Fprintf(&s, "complete -c %s -a '(%s)' -d branch", command, function)
+	return s.String()
+}
+
+// a complete command that starts with prefix for every option
+func fishOptions(s *strings.Builder, prefix string, flags []completionFlag,
+	function string) {
+	escape := strings.NewReplacer(`\`, `\\`, "'", `\'`)
+	for _, f := range flags {
+		fmt.Fprintf(s, "%[1]s -o %[2]s -l %[2]s -d '%[3]s'", prefix, f.name,
+			escape.Replace(f.usage))
+		if f.branch {
+			fmt.Fprintf(s, " -x -a '(%s)'", function)
+		} else if f.file {
+			s.WriteString(" -r -F")
+		} else if f.values != nil {
+			fmt.Fprintf(s, " -x -a '%s'", strings.Join(f.values, " "))
+		} else if !f.isBool {
+			s.WriteString(" -x")
+		}
+		s.WriteString("\n")
+	}
+}
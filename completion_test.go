@@ -0,0 +1,84 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestCompletionFlags(t *testing.T) {
+	bash := bashCompletion("_greb")
+	zsh := zshCompletion("_greb")
+	fish := fishCompletion("git-greb")
+	for _, f := range completionFlags("", nil) {
+		if !strings.Contains(bash, "-"+f.name+" ") &&
+			!strings.Contains(bash, "-"+f.name+"\"") {
+			t.Error("bash", f.name)
+		}
+		if !strings.Contains(zsh, "{-"+f.name+",--"+f.name+"}'[") {
+			t.Error("zsh", f.name)
+		}
+		if !strings.Contains(fish, " -o "+f.name+" -l "+f.name+" ") {
+			t.Error("fish", f.name)
+		}
+	}
+	for _, name := range completionSubcommands() {
+		if !strings.Contains(bash, name+" ") || !strings.Contains(zsh, name) ||
+			!strings.Contains(fish, name) {
+			t.Error(name)
+		}
+	}
+}
+
+func TestCompletionBranches(t *testing.T) {
+	if s := bashCompletion("_greb"); !strings.Contains(s, `-C|--C)
+			COMPREPLY=( $(compgen -W "$branches" -- "$cur") )`) {
+		t.Error(s)
+	}
+	if s := gitBashCompletion("_git_greb"); !strings.Contains(s, `-C|--C)
+			__gitcomp "$(__git_heads)"`) {
+		t.Error(s)
+	}
+	if s := zshCompletion("_greb"); !strings.Contains(s,
+		`(change branch).]:branch:_greb_branches' \`) {
+		t.Error(s)
+	}
+	if s := fishCompletion("git-greb"); !strings.Contains(s,
+		"(change branch).' -x -a '(__git_greb_branches)'\n") {
+		t.Error(s)
+	}
+}
+
+func TestCompletionSubcommandFlags(t *testing.T) {
+	names, sets := completionFlagSets()
+	for _, name := range []string{"move", "split", "export", "watch"} {
+		if sets[name] == nil {
+			t.Error(name, names)
+		}
+	}
+	if collectFlagSet != nil || command != nil {
+		t.Error(command)
+	}
+	bash := bashCompletion("_greb")
+	zsh := zshCompletion("_greb")
+	fish := fishCompletion("git-greb")
+	if !strings.Contains(bash, `				-onto|--onto)
+					COMPREPLY=( $(compgen -W "$branches" -- "$cur") )`) {
+		t.Error(bash)
+	}
+	if !strings.Contains(bash, `				-format|--format)
+					COMPREPLY=( $(compgen -W "text log xlib" -- "$cur") )`) {
+		t.Error(bash)
+	}
+	if !strings.Contains(zsh, `{-format,--format}'[dot, mermaid, plantuml, svg, `+
+		`png or html]:format:(dot mermaid plantuml svg png html)' \`) {
+		t.Error(zsh)
+	}
+	if !strings.Contains(fish, "complete -c git-greb -n "+
+		"'__fish_seen_subcommand_from split' -o b -l b -d ") {
+		t.Error(fish)
+	}
+	if !strings.Contains(fish, "'__fish_seen_subcommand_from watch' -o fetch "+
+		"-l fetch -d ") {
+		t.Error(fish)
+	}
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// the writer of the events, nil if they are disabled
+var eventWriter io.Writer
+
+// the events are written by several goroutines with -j
+var eventMutex sync.Mutex
+
+type event struct {
+	Event    string   `json:"event"`
+	Time     string   `json:"time"`
+	Branch   string   `json:"branch,omitempty"`
+	Branches []string `json:"branches,omitempty"`
+	Argv     []string `json:"argv,omitempty"`
+	Duration float64  `json:"duration,omitempty"`
+	Status   *int     `json:"status,omitempty"`
+	Old      string   `json:"old,omitempty"`
+	New      string   `json:"new,omitempty"`
+	Key      string   `json:"key,omitempty"`
+	Value    string   `json:"value,omitempty"`
+	Action   string   `json:"action,omitempty"`
+	Error    string   `json:"error,omitempty"`
+}
+
+// it parses the option -events: json or json:<fd>
+func initEvents() (err error) {
+	if events == "" {
+		return
+	}
+	p := strings.SplitN(events, ":", 2)
+	if p[0] != "json" {
+		err = fmt.Errorf("unknown format of events: %s", p[0])
+		return
+	}
+	if len(p) == 1 {
+		eventWriter = os.Stdout
+		commandOutput = os.Stderr
+		return
+	}
+	var fd uint64
+	if fd, err = strconv.ParseUint(p[1], 10, 32); err != nil {
+		err = fmt.Errorf("invalid file descriptor of events: %s", p[1])
+		return
+	}
+	eventWriter = os.NewFile(uintptr(fd), "events")
+	return
+}
+
+func emitEvent(e event) {
+	if eventWriter == nil {
+		return
+	}
+	e.Time = time.Now().Format(time.RFC3339Nano)
+	b, err := json.Marshal(e)
+	if err != nil {
+		logPrintf("%s\n", err)
+		return
+	}
+	eventMutex.Lock()
+	defer eventMutex.Unlock()
+	eventWriter.Write(append(b, '\n'))
+}
+
+func emitGraphEvent(g *graph) {
+	var branches nodesort
+	for _, n := range g.nodes {
+		branches = append(branches, n)
+	}
+	sort.Sort(&branches)
+	var names []string
+	for _, n := range branches {
+		names = append(names, n.branch)
+	}
+	emitEvent(event{Event: "graph", Branches: names})
+}
+
+// the command must have finished
+func emitCommandEvent(cmd *exec.Cmd, duration time.Duration) {
+	status := -1
+	if cmd.ProcessState != nil {
+		status = cmd.ProcessState.ExitCode()
+	}
+	emitEvent(event{Event: "command", Argv: cmd.Args,
+		Duration: duration.Seconds(), Status: &status})
+}
+
+func emitFinishedEvent(err error) {
+	e := event{Event: "finished"}
+	if err != nil {
+		e.Error = err.Error()
+	}
+	emitEvent(e)
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// it restores the options of the events
+func restoreEvents(t *testing.T) {
+	oldevents, oldwriter, oldoutput := events, eventWriter, commandOutput
+	t.Cleanup(func() {
+		events, eventWriter, commandOutput = oldevents, oldwriter, oldoutput
+	})
+}
+
+func TestInitEvents(t *testing.T) {
+	restoreEvents(t)
+	events = ""
+	if err := initEvents(); err != nil || eventWriter != nil {
+		t.Error(eventWriter, err)
+	}
+	events = "json"
+	if err := initEvents(); err != nil || eventWriter != os.Stdout ||
+		commandOutput != os.Stderr {
+		t.Error(eventWriter, commandOutput, err)
+	}
+	for _, e := range []string{"xml", "json:x", "json:-1"} {
+		events = e
+		if err := initEvents(); err == nil {
+			t.Error(e)
+		}
+	}
+}
+
+func TestEmitEvent(t *testing.T) {
+	restoreEvents(t)
+	eventWriter = nil
+	// disabled
+	emitEvent(event{Event: "branch", Branch: "master"})
+	b := new(bytes.Buffer)
+	eventWriter = b
+	emitEvent(event{Event: "updated", Branch: "master", Old: "a", New: "b"})
+	emitFinishedEvent(errors.New("failed"))
+	s := bufio.NewScanner(b)
+	var e map[string]interface{}
+	if !s.Scan() {
+		t.Fatal(s.Err())
+	}
+	if err := json.Unmarshal(s.Bytes(), &e); err != nil {
+		t.Fatal(err)
+	}
+	if e["event"] != "updated" || e["branch"] != "master" || e["old"] != "a" ||
+		e["new"] != "b" || e["time"] == "" {
+		t.Error(e)
+	}
+	if _, ok := e["error"]; ok {
+		t.Error(e)
+	}
+	if !s.Scan() {
+		t.Fatal(s.Err())
+	}
+	if l := s.Text(); !strings.Contains(l, `"event":"finished"`) ||
+		!strings.Contains(l, `"error":"failed"`) {
+		t.Error(l)
+	}
+	if s.Scan() {
+		t.Error(s.Text())
+	}
+}
+
+func TestCheckEvents(t *testing.T) {
+	newTestRepository(t)
+	testGit(t, "checkout", "-q", "-b", "topic", "-t", "master")
+	testCommit(t, "topic")
+	restoreEvents(t)
+	f, err := os.Create(filepath.Join(t.TempDir(), "stdout"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	stdout := os.Stdout
+	defer func() {
+		os.Stdout, check = stdout, false
+	}()
+	os.Stdout, check, events = f, true, "json"
+	if err = initEvents(); err != nil {
+		t.Fatal(err)
+	}
+	// the text of the check goes to the standard error
+	commandOutput = new(bytes.Buffer)
+	if err = greb(nil); err != nil {
+		t.Fatal(err)
+	}
+	if o := commandOutput.(*bytes.Buffer).String(); o != "topic: up to date\n" {
+		t.Error(o)
+	}
+	output, _ := os.ReadFile(f.Name())
+	var found bool
+	for _, line := range strings.Split(strings.TrimSpace(string(output)), "\n") {
+		var e event
+		if err := json.Unmarshal([]byte(line), &e); err != nil {
+			t.Error(line)
+		}
+		found = found || e.Event == "graph"
+	}
+	if !found {
+		t.Error(string(output))
+	}
+}
@@ -2,7 +2,9 @@ package main
 
 import (
 	"fmt"
+	"html"
 	"sort"
+	"strings"
 )
 
 // ref identifier
@@ -21,20 +23,28 @@ type node struct {
 	upstreams map[*node]string
 	// nodes that depend on this node
 	downstreams map[*node]struct{}
+	// node that this node is pushed to, if any
+	push *node
+	// commits of this node that are not in push and vice versa
+	ahead, behind int
+	// values of branch.<name>.merge whose remote-tracking branches are gone
+	gone []string
 }
 
 type graph struct {
 	nodes map[ref]*node
+	// urls of the remotes, i.e. origin: https://github.com/user/repo.git
+	urls map[string]string
 }
 
 func newGraph() *graph {
 	nodes := make(map[ref]*node)
-	return &graph{nodes}
+	return &graph{nodes: nodes}
 }
 
 func (g *graph) node(r ref) (n *node, ok bool) {
 	if n, ok = g.nodes[r]; !ok {
-		n = &node{r, "", nil, nil}
+		n = &node{ref: r}
 		g.nodes[r] = n
 	}
 	return
@@ -51,6 +61,10 @@ func (g *graph) edge(from, to *node, reason string) {
 	to.downstreams[from] = struct{}{}
 }
 
+func (g *graph) pushEdge(from, to *node, ahead, behind int) {
+	from.push, from.ahead, from.behind = to, ahead, behind
+}
+
 // nodes n that len(n.upstreams) > 0, downstreams first
 func (g *graph) sort() (nodes []*node) {
 	pending := make(map[ref]*node, len(g.nodes))
@@ -82,6 +96,28 @@ func (g *graph) sort() (nodes []*node) {
 	return
 }
 
+// nodes that depend on n directly or indirectly, in the same order as sort
+func (g *graph) downstreamsOf(n *node) (nodes []*node) {
+	found := make(map[*node]struct{})
+	pending := []*node{n}
+	for len(pending) > 0 {
+		var p *node
+		p, pending = pending[0], pending[1:]
+		for d := range p.downstreams {
+			if _, ok := found[d]; !ok {
+				found[d] = struct{}{}
+				pending = append(pending, d)
+			}
+		}
+	}
+	for _, s := range g.sort() {
+		if _, ok := found[s]; ok && s != n {
+			nodes = append(nodes, s)
+		}
+	}
+	return
+}
+
 // for adding branch.<downstream>.merge = <upstream>
 type addUpstream struct {
 	downstream string
@@ -137,6 +173,41 @@ func (g *graph) remove(n *node) (updates []interface{}) {
 	return
 }
 
+// it returns instances of rmUpstream, addUpstream
+func (g *graph) rename(n *node, name, branch string) (updates []interface{}) {
+	for d := range n.downstreams {
+		updates = append(updates, rmUpstream{d.branch, d.upstreams[n]},
+			addUpstream{d.branch, name})
+		d.upstreams[n] = name
+	}
+	delete(g.nodes, n.ref)
+	n.name, n.branch = name, branch
+	g.nodes[n.ref] = n
+	return
+}
+
+// it inserts a new node between n and its upstreams
+// it returns instances of rmUpstream, addUpstream, setRemote
+func (g *graph) split(n *node, name, branch string) (m *node,
+	updates []interface{}) {
+	m, _ = g.node(ref{name, "."})
+	m.branch = branch
+	var remote string
+	for u, r := range n.upstreams {
+		remote = u.remote
+		g.edge(m, u, r)
+		delete(u.downstreams, n)
+		updates = append(updates, rmUpstream{n.branch, r}, addUpstream{branch, r})
+	}
+	if remote != "" {
+		updates = append(updates, setRemote{branch, remote})
+	}
+	n.upstreams = nil
+	g.edge(n, m, name)
+	updates = append(updates, setRemote{n.branch, "."}, addUpstream{n.branch, name})
+	return
+}
+
 type nodesort []*node
 
 func (ns nodesort) Len() int {
@@ -151,6 +222,33 @@ func (ns *nodesort) Swap(i, j int) {
 	(*ns)[i], (*ns)[j] = (*ns)[j], (*ns)[i]
 }
 
+// the branch with colors and the annotations of gone upstreams and push
+func (n *node) label(current, currentColor, remoteColor,
+	resetColor string) (s string) {
+	if n.branch == current {
+		s += fmt.Sprintf("%v%v%v", currentColor, n.branch, resetColor)
+	} else if n.remote != "." {
+		s += fmt.Sprintf("%v%v%v", remoteColor, n.branch, resetColor)
+	} else {
+		s += n.branch
+	}
+	if len(n.gone) > 0 {
+		s += " [gone]"
+	}
+	if p := n.push; p != nil {
+		s += fmt.Sprintf(" [push %v%v%v", remoteColor, p.branch, resetColor)
+		if n.ahead > 0 && n.behind > 0 {
+			s += fmt.Sprintf(": ahead %v, behind %v", n.ahead, n.behind)
+		} else if n.ahead > 0 {
+			s += fmt.Sprintf(": ahead %v", n.ahead)
+		} else if n.behind > 0 {
+			s += fmt.Sprintf(": behind %v", n.behind)
+		}
+		s += "]"
+	}
+	return
+}
+
 func (g *graph) text(n *node, indent, i string, current,
 	currentColor, remoteColor, resetColor string) (s string) {
 	if len(indent)/len(i) > 30 {
@@ -159,7 +257,8 @@ func (g *graph) text(n *node, indent, i string, current,
 	var nodes nodesort
 	if n == nil {
 		for _, n := range g.nodes {
-			if len(n.upstreams) == 0 {
+			// remote nodes without downstreams are only push destinations
+			if len(n.upstreams) == 0 && (n.remote == "." || len(n.downstreams) > 0) {
 				nodes = append(nodes, n)
 			}
 		}
@@ -168,13 +267,8 @@ func (g *graph) text(n *node, indent, i string, current,
 	}
 	sort.Sort(&nodes)
 	for _, n := range nodes {
-		if n.branch == current {
-			s += fmt.Sprintf("%v%v%v%v\n", indent, currentColor, n.branch, resetColor)
-		} else if n.remote != "." {
-			s += fmt.Sprintf("%v%v%v%v\n", indent, remoteColor, n.branch, resetColor)
-		} else {
-			s += fmt.Sprintf("%v%v\n", indent, n.branch)
-		}
+		s += indent + n.label(current, currentColor, remoteColor, resetColor)
+		s += "\n"
 		var downstreams nodesort
 		for d := range n.downstreams {
 			downstreams = append(downstreams, d)
@@ -187,22 +281,209 @@ func (g *graph) text(n *node, indent, i string, current,
 	return
 }
 
+// nodes sorted so that every node comes after its downstreams, the remote
+// nodes last
+func (g *graph) logOrder() (nodes []*node) {
+	pending := make(map[*node]int, len(g.nodes))
+	var stack, remotes nodesort
+	for _, n := range g.nodes {
+		if n.remote != "." {
+			// remote nodes without downstreams are only push destinations
+			if len(n.downstreams) > 0 {
+				remotes = append(remotes, n)
+			}
+			continue
+		}
+		pending[n] = len(n.downstreams)
+		if len(n.downstreams) == 0 {
+			stack = append(stack, n)
+		}
+	}
+	sort.Sort(sort.Reverse(&stack))
+	for len(stack) > 0 {
+		n := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		nodes = append(nodes, n)
+		delete(pending, n)
+		var upstreams nodesort
+		for u := range n.upstreams {
+			if _, ok := pending[u]; ok {
+				if pending[u]--; pending[u] == 0 {
+					upstreams = append(upstreams, u)
+				}
+			}
+		}
+		sort.Sort(sort.Reverse(&upstreams))
+		stack = append(stack, upstreams...)
+	}
+	// the cycles
+	var cycles nodesort
+	for n := range pending {
+		cycles = append(cycles, n)
+	}
+	sort.Sort(&cycles)
+	sort.Sort(&remotes)
+	nodes = append(nodes, cycles...)
+	nodes = append(nodes, remotes...)
+	return
+}
+
+// it draws the graph once in the same way as git log --graph, every node is
+// drawn above its upstreams
+func (g *graph) log(current, currentColor, remoteColor,
+	resetColor string) (s string) {
+	var lanes []*node
+	printed := make(map[*node]struct{}, len(g.nodes))
+	for _, n := range g.logOrder() {
+		col := -1
+		for i, l := range lanes {
+			if l == n {
+				col = i
+				break
+			}
+		}
+		if col < 0 {
+			lanes = append(lanes, n)
+			col = len(lanes) - 1
+		}
+		// the joins of the lanes of several downstreams
+		for j := col + 1; j < len(lanes); {
+			if lanes[j] != n {
+				j++
+				continue
+			}
+			s += logLine(len(lanes), func(i int) (lane, gap byte) {
+				if i < j {
+					lane = '|'
+				}
+				if i >= col && i < j-1 {
+					gap = '_'
+				} else if i >= j-1 && i < len(lanes)-1 {
+					gap = '/'
+				}
+				return
+			})
+			lanes = append(lanes[:j], lanes[j+1:]...)
+		}
+		for i := range lanes {
+			if i == col {
+				s += "* "
+			} else {
+				s += "| "
+			}
+		}
+		s += n.label(current, currentColor, remoteColor, resetColor) + "\n"
+		printed[n] = struct{}{}
+		var upstreams nodesort
+		for u := range n.upstreams {
+			if _, ok := printed[u]; !ok {
+				upstreams = append(upstreams, u)
+			}
+		}
+		sort.Sort(&upstreams)
+		if len(upstreams) == 0 {
+			// the lane ends
+			if col < len(lanes)-1 {
+				s += logLine(len(lanes), func(i int) (lane, gap byte) {
+					if i < col {
+						lane = '|'
+					} else if i >= col && i < len(lanes)-1 {
+						gap = '/'
+					}
+					return
+				})
+			}
+			lanes = append(lanes[:col], lanes[col+1:]...)
+			continue
+		}
+		lanes[col] = upstreams[0]
+		// the splits of the lanes of several upstreams
+		for k := len(upstreams) - 1; k > 0; k-- {
+			s += logLine(len(lanes), func(i int) (lane, gap byte) {
+				if i <= col {
+					lane = '|'
+				}
+				if i >= col {
+					gap = '\\'
+				}
+				return
+			})
+			lanes = append(lanes[:col+1], append([]*node{upstreams[k]},
+				lanes[col+1:]...)...)
+		}
+	}
+	return
+}
+
+// a line between nodes, f returns the characters of every lane and the gap at
+// its right
+func logLine(lanes int, f func(i int) (lane, gap byte)) string {
+	b := make([]byte, 0, 2*lanes)
+	for i := 0; i < lanes; i++ {
+		lane, gap := f(i)
+		if lane == 0 {
+			lane = ' '
+		}
+		if gap == 0 {
+			gap = ' '
+		}
+		b = append(b, lane, gap)
+	}
+	return strings.TrimRight(string(b), " ") + "\n"
+}
+
 func (g *graph) dot(branch, currentColor, remoteColor string) (s string) {
+	return g.dotTooltips(branch, currentColor, remoteColor, nil)
+}
+
+// the same as dot, the tooltips are shown when the pointer hovers the nodes in
+// svg
+func (g *graph) dotTooltips(branch, currentColor, remoteColor string,
+	tooltips map[*node]string) (s string) {
 	var nodes nodesort
 	for _, n := range g.nodes {
 		nodes = append(nodes, n)
 	}
 	sort.Sort(&nodes)
 	s += "digraph {\n"
+	// the remote branches are declared in a cluster for every remote
+	clusters := make(map[string][]string)
+	var remotes, bottom []string
 	for _, n := range nodes {
+		if n.remote == "." {
+			continue
+		}
+		if _, ok := clusters[n.remote]; !ok {
+			remotes = append(remotes, n.remote)
+			clusters[n.remote] = nil
+		}
+		bottom = append(bottom, fmt.Sprintf("\"%v\"", n.branch))
+	}
+	sort.Strings(remotes)
+	if len(remotes) > 0 {
+		// the ranks are global across the clusters
+		s += "  newrank=true;\n"
+	}
+	for _, n := range nodes {
+		var attrs []string
 		if n.branch == branch && currentColor != "" {
-			s += fmt.Sprintf("  \"%v\" [color=\"%[2]v\", fontcolor=\"%[2]v\"];\n",
-				n.branch, currentColor)
+			attrs = append(attrs, fmt.Sprintf("color=\"%[1]v\", fontcolor=\"%[1]v\"",
+				currentColor))
 		} else if n.remote != "." && remoteColor != "" {
-			s += fmt.Sprintf("  \"%v\" [color=\"%[2]v\", fontcolor=\"%[2]v\"];\n",
-				n.branch, remoteColor)
+			attrs = append(attrs, fmt.Sprintf("color=\"%[1]v\", fontcolor=\"%[1]v\"",
+				remoteColor))
+		}
+		if t, ok := tooltips[n]; ok {
+			attrs = append(attrs, fmt.Sprintf("tooltip=%q", t))
+		}
+		decl := fmt.Sprintf("\"%v\";", n.branch)
+		if len(attrs) > 0 {
+			decl = fmt.Sprintf("\"%v\" [%v];", n.branch, strings.Join(attrs, ", "))
+		}
+		if n.remote != "." {
+			clusters[n.remote] = append(clusters[n.remote], decl)
 		} else {
-			s += fmt.Sprintf("  \"%v\";\n", n.branch)
+			s += "  " + decl + "\n"
 		}
 		var upstreams nodesort
 		for u := range n.upstreams {
@@ -216,7 +497,259 @@ func (g *graph) dot(branch, currentColor, remoteColor string) (s string) {
 			}
 			s += fmt.Sprintf("  \"%v\" -> \"%v\"%v;\n", n.branch, u.branch, style)
 		}
+		if p := n.push; p != nil {
+			s += fmt.Sprintf("  \"%v\" -> \"%v\" [style=dashed, label=\"+%v -%v\"];\n",
+				n.branch, p.branch, n.ahead, n.behind)
+		}
+	}
+	for _, remote := range remotes {
+		label := remote
+		if url, ok := g.urls[remote]; ok {
+			label += "\n" + url
+		}
+		s += fmt.Sprintf("  subgraph \"cluster_%v\" {\n", remote)
+		s += fmt.Sprintf("    label=%q;\n", label)
+		for _, decl := range clusters[remote] {
+			s += "    " + decl + "\n"
+		}
+		s += "  }\n"
+	}
+	// the local branches are laid out above their remote bases
+	if len(bottom) > 0 {
+		s += fmt.Sprintf("  {rank=max; %v;}\n", strings.Join(bottom, "; "))
 	}
 	s += "}\n"
 	return
 }
+
+// node identifiers for mermaid and plantuml, in the order of the nodes
+func (g *graph) sortedIds() (nodes nodesort, ids map[*node]string) {
+	for _, n := range g.nodes {
+		nodes = append(nodes, n)
+	}
+	sort.Sort(&nodes)
+	ids = make(map[*node]string, len(nodes))
+	for i, n := range nodes {
+		ids[n] = fmt.Sprintf("n%d", i)
+	}
+	return
+}
+
+// the names of the colors 0 to 7 of git, 8 to 15 are their bright versions
+var colorNames = []string{"black", "red", "green", "yellow", "blue", "magenta",
+	"cyan", "white"}
+
+// the foreground of a git color as a web color, i.e. bold green is green and
+// 196 is #ff0000, the attributes are dropped, it is empty if there is none
+func webColor(color string) string {
+	for _, w := range strings.Fields(color) {
+		w = strings.ToLower(w)
+		if strings.HasPrefix(w, "#") {
+			return w
+		}
+		for i, name := range colorNames {
+			if w == name || w == "bright"+name {
+				return colorNames[i]
+			}
+		}
+		var n int
+		if _, err := fmt.Sscanf(w, "%d", &n); err != nil || n < 0 || n > 255 {
+			continue
+		}
+		if n < 16 {
+			return colorNames[n%8]
+		} else if n >= 232 {
+			v := 8 + 10*(n-232)
+			return fmt.Sprintf("#%02x%02x%02x", v, v, v)
+		}
+		// the cube of 6x6x6 colors
+		levels := []int{0, 95, 135, 175, 215, 255}
+		n -= 16
+		return fmt.Sprintf("#%02x%02x%02x", levels[n/36], levels[n/6%6],
+			levels[n%6])
+	}
+	return ""
+}
+
+func (g *graph) mermaid(branch, currentColor, remoteColor string) (s string) {
+	currentColor, remoteColor = webColor(currentColor), webColor(remoteColor)
+	nodes, ids := g.sortedIds()
+	s += "flowchart BT\n"
+	for _, n := range nodes {
+		s += fmt.Sprintf("  %v[\"%v\"]\n", ids[n],
+			strings.Replace(n.branch, "\"", "#quot;", -1))
+	}
+	for _, n := range nodes {
+		var upstreams nodesort
+		for u := range n.upstreams {
+			upstreams = append(upstreams, u)
+		}
+		sort.Sort(&upstreams)
+		for _, u := range upstreams {
+			arrow := "-->"
+			if u.remote != "." {
+				arrow = "-.->"
+			}
+			s += fmt.Sprintf("  %v %v %v\n", ids[n], arrow, ids[u])
+		}
+		if p := n.push; p != nil {
+			s += fmt.Sprintf("  %v == \"+%v -%v\" ==> %v\n", ids[n], n.ahead,
+				n.behind, ids[p])
+		}
+	}
+	for _, n := range nodes {
+		if n.branch == branch && currentColor != "" {
+			s += fmt.Sprintf("  style %v stroke:%[2]v,color:%[2]v\n", ids[n],
+				currentColor)
+		} else if n.remote != "." && remoteColor != "" {
+			s += fmt.Sprintf("  style %v stroke:%[2]v,color:%[2]v\n", ids[n],
+				remoteColor)
+		}
+	}
+	return
+}
+
+func (g *graph) plantuml(branch, currentColor, remoteColor string) (s string) {
+	// the hexadecimal colors go without # after line: and text:
+	currentColor = strings.TrimPrefix(webColor(currentColor), "#")
+	remoteColor = strings.TrimPrefix(webColor(remoteColor), "#")
+	nodes, ids := g.sortedIds()
+	s += "@startuml\n"
+	for _, n := range nodes {
+		var style string
+		if n.branch == branch && currentColor != "" {
+			style = fmt.Sprintf(" #line:%[1]v;text:%[1]v", currentColor)
+		} else if n.remote != "." && remoteColor != "" {
+			style = fmt.Sprintf(" #line:%[1]v;text:%[1]v", remoteColor)
+		}
+		s += fmt.Sprintf("rectangle \"%v\" as %v%v\n",
+			strings.Replace(n.branch, "\"", "&#34;", -1), ids[n], style)
+	}
+	for _, n := range nodes {
+		var upstreams nodesort
+		for u := range n.upstreams {
+			upstreams = append(upstreams, u)
+		}
+		sort.Sort(&upstreams)
+		for _, u := range upstreams {
+			arrow := "-->"
+			if u.remote != "." {
+				arrow = "..>"
+			}
+			s += fmt.Sprintf("%v %v %v\n", ids[n], arrow, ids[u])
+		}
+		if p := n.push; p != nil {
+			s += fmt.Sprintf("%v -[dashed]-> %v : +%v -%v\n", ids[n], ids[p],
+				n.ahead, n.behind)
+		}
+	}
+	s += "@enduml\n"
+	return
+}
+
+// it lays out the graph in layers without graphviz, the downstreams above their
+// upstreams, the tooltips are shown when the pointer hovers the nodes
+func (g *graph) svg(branch, currentColor, remoteColor string,
+	tooltips map[*node]string) (s string) {
+	const width, height, dx, dy = 140, 30, 170, 70
+	// the longest path to a node without upstreams
+	layers := make(map[*node]int, len(g.nodes))
+	var layer func(n *node, depth int) int
+	layer = func(n *node, depth int) int {
+		if l, ok := layers[n]; ok {
+			return l
+		}
+		l := 0
+		// it stops the cycles
+		if depth < len(g.nodes) {
+			for u := range n.upstreams {
+				if ul := layer(u, depth+1) + 1; ul > l {
+					l = ul
+				}
+			}
+		}
+		layers[n] = l
+		return l
+	}
+	var rows []nodesort
+	for _, n := range g.nodes {
+		l := layer(n, 0)
+		for len(rows) <= l {
+			rows = append(rows, nil)
+		}
+		rows[l] = append(rows[l], n)
+	}
+	type point struct{ x, y int }
+	centers := make(map[*node]point, len(g.nodes))
+	columns := 0
+	for l, row := range rows {
+		sort.Sort(&row)
+		if len(row) > columns {
+			columns = len(row)
+		}
+		for i, n := range row {
+			centers[n] = point{dx/2 + i*dx, dy/2 + (len(rows)-1-l)*dy}
+		}
+	}
+	color := func(n *node) string {
+		if n.branch == branch && currentColor != "" {
+			return currentColor
+		} else if n.remote != "." && remoteColor != "" {
+			return remoteColor
+		}
+		return "black"
+	}
+	s += fmt.Sprintf("<svg xmlns=\"http://www.w3.org/2000/svg\" "+
+		"width=\"%v\" height=\"%v\" font-family=\"sans-serif\" font-size=\"12\">\n",
+		columns*dx, len(rows)*dy)
+	s += "<defs><marker id=\"arrow\" viewBox=\"0 0 10 10\" refX=\"10\" refY=\"5\" " +
+		"markerWidth=\"8\" markerHeight=\"8\" orient=\"auto\">" +
+		"<path d=\"M 0 0 L 10 5 L 0 10 z\"/></marker></defs>\n"
+	var nodes nodesort
+	for n := range centers {
+		nodes = append(nodes, n)
+	}
+	sort.Sort(&nodes)
+	for _, n := range nodes {
+		var upstreams nodesort
+		for u := range n.upstreams {
+			upstreams = append(upstreams, u)
+		}
+		sort.Sort(&upstreams)
+		from := centers[n]
+		for _, u := range upstreams {
+			to := centers[u]
+			var dash string
+			if u.remote != "." {
+				dash = " stroke-dasharray=\"2,3\""
+			}
+			s += fmt.Sprintf("<line x1=\"%v\" y1=\"%v\" x2=\"%v\" y2=\"%v\" "+
+				"stroke=\"black\"%v marker-end=\"url(#arrow)\"/>\n",
+				from.x, from.y+height/2, to.x, to.y-height/2, dash)
+		}
+		if p := n.push; p != nil {
+			to := centers[p]
+			s += fmt.Sprintf("<line x1=\"%v\" y1=\"%v\" x2=\"%v\" y2=\"%v\" "+
+				"stroke=\"black\" stroke-dasharray=\"6,3\" marker-end=\"url(#arrow)\"/>\n",
+				from.x, from.y+height/2, to.x, to.y-height/2)
+			s += fmt.Sprintf("<text x=\"%v\" y=\"%v\" text-anchor=\"middle\">+%v -%v</text>\n",
+				(from.x+to.x)/2, (from.y+to.y)/2, n.ahead, n.behind)
+		}
+	}
+	for _, n := range nodes {
+		c := centers[n]
+		s += "<g>"
+		if t, ok := tooltips[n]; ok {
+			s += "<title>" + html.EscapeString(t) + "</title>"
+		}
+		s += fmt.Sprintf("<rect x=\"%v\" y=\"%v\" width=\"%v\" height=\"%v\" "+
+			"rx=\"8\" fill=\"white\" stroke=\"%v\"/>", c.x-width/2, c.y-height/2,
+			width, height, color(n))
+		s += fmt.Sprintf("<text x=\"%v\" y=\"%v\" text-anchor=\"middle\" "+
+			"dominant-baseline=\"middle\" fill=\"%v\">%v</text>", c.x, c.y, color(n),
+			html.EscapeString(n.branch))
+		s += "</g>\n"
+	}
+	s += "</svg>\n"
+	return
+}
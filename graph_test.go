@@ -37,6 +37,39 @@ func TestGraphSort2(t *testing.T) {
 	}
 }
 
+func TestGraphDownstreamsOf(t *testing.T) {
+	g := newGraph()
+	a, _ := g.node(ref{"a", "."})
+	b, _ := g.node(ref{"b", "."})
+	c, _ := g.node(ref{"c", "."})
+	d, _ := g.node(ref{"d", "."})
+	e, _ := g.node(ref{"e", "."})
+	g.edge(a, b, "ab")
+	g.edge(b, c, "bc")
+	g.edge(d, c, "dc")
+	g.edge(c, e, "ce")
+	nodes := g.downstreamsOf(c)
+	if l := len(nodes); l != 3 {
+		t.Fatal(l)
+	}
+	found := map[*node]int{}
+	for i, n := range nodes {
+		found[n] = i
+	}
+	if l := len(found); l != 3 {
+		t.Error(l)
+	}
+	if i, ok := found[a]; !ok || i != 2 {
+		t.Error(i, ok)
+	}
+	if _, ok := found[b]; !ok {
+		t.Error(ok)
+	}
+	if _, ok := found[d]; !ok {
+		t.Error(ok)
+	}
+}
+
 func TestGraphRemove(t *testing.T) {
 	g := newGraph()
 	a, _ := g.node(ref{"a", "."})
@@ -152,6 +185,117 @@ func TestGraphRemove(t *testing.T) {
 	}
 }
 
+func TestGraphRename(t *testing.T) {
+	g := newGraph()
+	a, _ := g.node(ref{"a", "."})
+	b, _ := g.node(ref{"b", "."})
+	c, _ := g.node(ref{"c", "."})
+	for _, n := range []*node{a, b, c} {
+		n.branch = strings.Repeat(n.name, 2)
+	}
+	g.edge(a, b, "ab")
+	g.edge(b, c, "bc")
+	updates := g.rename(b, "d", "dd")
+	if l := len(updates); l != 2 {
+		t.Error(l)
+	} else if u := updates[0]; u != (rmUpstream{"aa", "ab"}) {
+		t.Error(u)
+	} else if u := updates[1]; u != (addUpstream{"aa", "d"}) {
+		t.Error(u)
+	}
+	if l := len(g.nodes); l != 3 {
+		t.Error(l)
+	}
+	if _, ok := g.nodes[ref{"b", "."}]; ok {
+		t.Error(ok)
+	}
+	if n, ok := g.nodes[ref{"d", "."}]; !ok || n != b {
+		t.Error(n, ok)
+	}
+	if e := b.branch; e != "dd" {
+		t.Error(e)
+	}
+	if e := a.upstreams[b]; e != "d" {
+		t.Error(e)
+	}
+	if e := b.upstreams[c]; e != "bc" {
+		t.Error(e)
+	}
+}
+
+func TestGraphSplit(t *testing.T) {
+	g := newGraph()
+	a, _ := g.node(ref{"a", "."})
+	b, _ := g.node(ref{"b", "origin"})
+	c, _ := g.node(ref{"c", "origin"})
+	for _, n := range []*node{a, b, c} {
+		n.branch = strings.Repeat(n.name, 2)
+	}
+	g.edge(a, b, "ab")
+	g.edge(a, c, "ac")
+	m, updates := g.split(a, "d", "dd")
+	if l := len(updates); l != 7 {
+		t.Error(l)
+	} else {
+		for i, u := range updates[:4] {
+			if _, ok := u.(rmUpstream); ok && i%2 != 0 {
+				t.Error(i, u)
+			}
+		}
+		bools := map[interface{}]bool{
+			rmUpstream{"aa", "ab"}:    false,
+			rmUpstream{"aa", "ac"}:    false,
+			addUpstream{"dd", "ab"}:   false,
+			addUpstream{"dd", "ac"}:   false,
+			setRemote{"dd", "origin"}: false,
+			setRemote{"aa", "."}:      false,
+			addUpstream{"aa", "d"}:    false,
+		}
+		for _, u := range updates {
+			if _, ok := bools[u]; !ok {
+				t.Error(u)
+			} else {
+				bools[u] = true
+			}
+		}
+		for u, b := range bools {
+			if !b {
+				t.Error(u)
+			}
+		}
+		if u := updates[6]; u != (addUpstream{"aa", "d"}) {
+			t.Error(u)
+		}
+	}
+	if n, ok := g.nodes[ref{"d", "."}]; !ok || n != m {
+		t.Error(n, ok)
+	}
+	if e := m.branch; e != "dd" {
+		t.Error(e)
+	}
+	if l := len(a.upstreams); l != 1 {
+		t.Error(l)
+	}
+	if e := a.upstreams[m]; e != "d" {
+		t.Error(e)
+	}
+	if l := len(m.upstreams); l != 2 {
+		t.Error(l)
+	}
+	if e := m.upstreams[b]; e != "ab" {
+		t.Error(e)
+	}
+	if e := m.upstreams[c]; e != "ac" {
+		t.Error(e)
+	}
+	if _, ok := b.downstreams[a]; ok {
+		t.Error(ok)
+	}
+	if _, ok := c.downstreams[m]; !ok {
+		t.Error(ok)
+	}
+}
+
 func TestGraphText(t *testing.T) {
 	g := newGraph()
 	a, _ := g.node(ref{"a", "."})
@@ -206,6 +350,36 @@ func TestGraphText(t *testing.T) {
 	}
 }
 
+func TestGraphTextWithAnnotations(t *testing.T) {
+	g := newGraph()
+	a, _ := g.node(ref{"a", "."})
+	b, _ := g.node(ref{"b", "origin"})
+	c, _ := g.node(ref{"a", "fork"})
+	for _, n := range []*node{a, b, c} {
+		n.branch = strings.Repeat(n.name, 2)
+	}
+	c.branch = "fork/a"
+	g.edge(a, b, "ab")
+	g.pushEdge(a, c, 2, 1)
+	a.gone = []string{"refs/heads/a"}
+	s := bufio.NewScanner(bytes.NewBufferString(g.text(nil, "", "  ", "", "", "", "")))
+	if v := s.Scan(); !v {
+		t.Fatal(v)
+	}
+	if e := s.Text(); e != "bb" {
+		t.Error(e)
+	}
+	if v := s.Scan(); !v {
+		t.Fatal(v)
+	}
+	if e := s.Text(); e != "  aa [gone] [push fork/a: ahead 2, behind 1]" {
+		t.Error(e)
+	}
+	if v := s.Scan(); v {
+		t.Fatal(v)
+	}
+}
+
 func TestGraphDotWithoutColor(t *testing.T) {
 	g := newGraph()
 	a, _ := g.node(ref{"a", "."})
@@ -226,6 +400,12 @@ func TestGraphDotWithoutColor(t *testing.T) {
 	if v := s.Scan(); !v {
 		t.Fatal(v)
 	}
+	if e := s.Text(); e != "  newrank=true;" {
+		t.Error(e)
+	}
+	if v := s.Scan(); !v {
+		t.Fatal(v)
+	}
 	if e := s.Text(); e != "  \"aa\";" {
 		t.Error(e)
 	}
@@ -250,7 +430,31 @@ func TestGraphDotWithoutColor(t *testing.T) {
 	if v := s.Scan(); !v {
 		t.Fatal(v)
 	}
-	if e := s.Text(); e != "  \"cc\";" {
+	if e := s.Text(); e != "  subgraph \"cluster_origin\" {" {
+		t.Error(e)
+	}
+	if v := s.Scan(); !v {
+		t.Fatal(v)
+	}
+	if e := s.Text(); e != "    label=\"origin\";" {
+		t.Error(e)
+	}
+	if v := s.Scan(); !v {
+		t.Fatal(v)
+	}
+	if e := s.Text(); e != "    \"cc\";" {
+		t.Error(e)
+	}
+	if v := s.Scan(); !v {
+		t.Fatal(v)
+	}
+	if e := s.Text(); e != "  }" {
+		t.Error(e)
+	}
+	if v := s.Scan(); !v {
+		t.Fatal(v)
+	}
+	if e := s.Text(); e != "  {rank=max; \"cc\";}" {
 		t.Error(e)
 	}
 	if v := s.Scan(); !v {
@@ -284,6 +488,12 @@ func TestGraphDotWithColor(t *testing.T) {
 	if v := s.Scan(); !v {
 		t.Fatal(v)
 	}
+	if e := s.Text(); e != "  newrank=true;" {
+		t.Error(e)
+	}
+	if v := s.Scan(); !v {
+		t.Fatal(v)
+	}
 	if e := s.Text(); e != "  \"aa\";" {
 		t.Error(e)
 	}
@@ -308,7 +518,103 @@ func TestGraphDotWithColor(t *testing.T) {
 	if v := s.Scan(); !v {
 		t.Fatal(v)
 	}
-	if e := s.Text(); e != "  \"cc\" [color=\"red\", fontcolor=\"red\"];" {
+	if e := s.Text(); e != "  subgraph \"cluster_origin\" {" {
+		t.Error(e)
+	}
+	if v := s.Scan(); !v {
+		t.Fatal(v)
+	}
+	if e := s.Text(); e != "    label=\"origin\";" {
+		t.Error(e)
+	}
+	if v := s.Scan(); !v {
+		t.Fatal(v)
+	}
+	if e := s.Text(); e != "    \"cc\" [color=\"red\", fontcolor=\"red\"];" {
+		t.Error(e)
+	}
+	if v := s.Scan(); !v {
+		t.Fatal(v)
+	}
+	if e := s.Text(); e != "  }" {
+		t.Error(e)
+	}
+	if v := s.Scan(); !v {
+		t.Fatal(v)
+	}
+	if e := s.Text(); e != "  {rank=max; \"cc\";}" {
+		t.Error(e)
+	}
+	if v := s.Scan(); !v {
+		t.Fatal(v)
+	}
+	if e := s.Text(); e != "}" {
+		t.Error(e)
+	}
+	if v := s.Scan(); v {
+		t.Fatal(v)
+	}
+}
+
+func TestGraphDotWithPush(t *testing.T) {
+	g := newGraph()
+	a, _ := g.node(ref{"a", "."})
+	b, _ := g.node(ref{"a", "origin"})
+	a.branch, b.branch = "aa", "origin/aa"
+	g.pushEdge(a, b, 0, 3)
+	s := bufio.NewScanner(bytes.NewBufferString(g.dot("", "", "")))
+	if v := s.Scan(); !v {
+		t.Fatal(v)
+	}
+	if e := s.Text(); e != "digraph {" {
+		t.Error(e)
+	}
+	if v := s.Scan(); !v {
+		t.Fatal(v)
+	}
+	if e := s.Text(); e != "  newrank=true;" {
+		t.Error(e)
+	}
+	if v := s.Scan(); !v {
+		t.Fatal(v)
+	}
+	if e := s.Text(); e != "  \"aa\";" {
+		t.Error(e)
+	}
+	if v := s.Scan(); !v {
+		t.Fatal(v)
+	}
+	if e := s.Text(); e != "  \"aa\" -> \"origin/aa\" [style=dashed, label=\"+0 -3\"];" {
+		t.Error(e)
+	}
+	if v := s.Scan(); !v {
+		t.Fatal(v)
+	}
+	if e := s.Text(); e != "  subgraph \"cluster_origin\" {" {
+		t.Error(e)
+	}
+	if v := s.Scan(); !v {
+		t.Fatal(v)
+	}
+	if e := s.Text(); e != "    label=\"origin\";" {
+		t.Error(e)
+	}
+	if v := s.Scan(); !v {
+		t.Fatal(v)
+	}
+	if e := s.Text(); e != "    \"origin/aa\";" {
+		t.Error(e)
+	}
+	if v := s.Scan(); !v {
+		t.Fatal(v)
+	}
+	if e := s.Text(); e != "  }" {
+		t.Error(e)
+	}
+	if v := s.Scan(); !v {
+		t.Fatal(v)
+	}
+	if e := s.Text(); e != "  {rank=max; \"origin/aa\";}" {
 		t.Error(e)
 	}
 	if v := s.Scan(); !v {
@@ -321,3 +627,208 @@ func TestGraphDotWithColor(t *testing.T) {
 		t.Fatal(v)
 	}
 }
+
+func TestGraphDotClusters(t *testing.T) {
+	g := newRendererGraph()
+	g.urls = map[string]string{"origin": "https://example.com/repo.git"}
+	a, _ := g.node(ref{"a", "."})
+	d, _ := g.node(ref{"d", "upstream"})
+	d.branch = "upstream/dd"
+	g.edge(a, d, "ad")
+	s := g.dot("", "", "")
+	e := `digraph {
+  newrank=true;
+  "aa";
+  "aa" -> "bb";
+  "aa" -> "upstream/dd" [style=dotted];
+  "aa" -> "cc" [style=dashed, label="+1 -2"];
+  "bb";
+  "bb" -> "cc" [style=dotted];
+  subgraph "cluster_origin" {
+    label="origin\nhttps://example.com/repo.git";
+    "cc";
+  }
+  subgraph "cluster_upstream" {
+    label="upstream";
+    "upstream/dd";
+  }
+  {rank=max; "cc"; "upstream/dd";}
+}
+`
+	if s != e {
+		t.Error(s)
+	}
+}
+
+func newRendererGraph() *graph {
+	g := newGraph()
+	a, _ := g.node(ref{"a", "."})
+	b, _ := g.node(ref{"b", "."})
+	c, _ := g.node(ref{"c", "origin"})
+	for _, n := range []*node{a, b, c} {
+		n.branch = strings.Repeat(n.name, 2)
+	}
+	g.edge(a, b, "ab")
+	g.edge(b, c, "bc")
+	g.pushEdge(a, c, 1, 2)
+	return g
+}
+
+func TestGraphMermaid(t *testing.T) {
+	s := newRendererGraph().mermaid("bb", "green", "red")
+	e := `flowchart BT
+  n0["aa"]
+  n1["bb"]
+  n2["cc"]
+  n0 --> n1
+  n0 == "+1 -2" ==> n2
+  n1 -.-> n2
+  style n1 stroke:green,color:green
+  style n2 stroke:red,color:red
+`
+	if s != e {
+		t.Error(s)
+	}
+}
+
+func TestGraphPlantuml(t *testing.T) {
+	s := newRendererGraph().plantuml("bb", "", "")
+	e := `@startuml
+rectangle "aa" as n0
+rectangle "bb" as n1
+rectangle "cc" as n2
+n0 --> n1
+n0 -[dashed]-> n2 : +1 -2
+n1 ..> n2
+@enduml
+`
+	if s != e {
+		t.Error(s)
+	}
+}
+
+func TestGraphMermaidQuotesAndColors(t *testing.T) {
+	g := newRendererGraph()
+	for _, n := range g.nodes {
+		if n.name == "a" {
+			n.branch = "a\"a"
+		}
+	}
+	s := g.mermaid("bb", "bold green", "#FF0000 ul")
+	e := `flowchart BT
+  n0["a#quot;a"]
+  n1["bb"]
+  n2["cc"]
+  n0 --> n1
+  n0 == "+1 -2" ==> n2
+  n1 -.-> n2
+  style n1 stroke:green,color:green
+  style n2 stroke:#ff0000,color:#ff0000
+`
+	if s != e {
+		t.Error(s)
+	}
+}
+
+func TestGraphPlantumlQuotesAndColors(t *testing.T) {
+	g := newRendererGraph()
+	for _, n := range g.nodes {
+		if n.name == "a" {
+			n.branch = "a\"a"
+		}
+	}
+	s := g.plantuml("bb", "bold brightgreen", "ul 196 black")
+	e := `@startuml
+rectangle "a&#34;a" as n0
+rectangle "bb" as n1 #line:green;text:green
+rectangle "cc" as n2 #line:ff0000;text:ff0000
+n0 --> n1
+n0 -[dashed]-> n2 : +1 -2
+n1 ..> n2
+@enduml
+`
+	if s != e {
+		t.Error(s)
+	}
+}
+
+func TestWebColor(t *testing.T) {
+	for color, e := range map[string]string{
+		"green":          "green",
+		"bold green":     "green",
+		"brightred blue": "red",
+		"#FF0000 ul":     "#ff0000",
+		"9":              "red",
+		"231":            "#ffffff",
+		"232":            "#080808",
+		"bold":           "",
+		"normal":         "",
+	} {
+		if c := webColor(color); c != e {
+			t.Error(color, c)
+		}
+	}
+}
+
+func TestGraphLog(t *testing.T) {
+	g := newGraph()
+	a, _ := g.node(ref{"a", "."})
+	b, _ := g.node(ref{"b", "."})
+	c, _ := g.node(ref{"c", "."})
+	d, _ := g.node(ref{"d", "."})
+	e, _ := g.node(ref{"e", "origin"})
+	f, _ := g.node(ref{"f", "."})
+	for _, n := range []*node{a, b, c, d, e, f} {
+		n.branch = strings.Repeat(n.name, 2)
+	}
+	g.edge(a, b, "ab")
+	g.edge(a, c, "ac")
+	g.edge(b, d, "bd")
+	g.edge(c, d, "cd")
+	g.edge(d, e, "de")
+	g.edge(f, e, "fe")
+	s := g.log("bb", "^", "0", "$")
+	x := `* aa
+|\
+* | ^bb$
+| * cc
+|/
+* dd
+| * ff
+|/
+* 0ee$
+`
+	if s != x {
+		t.Error(s)
+	}
+}
+
+func TestGraphDotWithTooltips(t *testing.T) {
+	g := newRendererGraph()
+	a, _ := g.node(ref{"a", "."})
+	s := g.dotTooltips("aa", "green", "", map[*node]string{a: "bb: \"ab\"\nsubject"})
+	e := `  "aa" [color="green", fontcolor="green", tooltip="bb: \"ab\"\nsubject"];`
+	if !strings.Contains(s, e+"\n") {
+		t.Error(s)
+	}
+}
+
+func TestGraphSvg(t *testing.T) {
+	g := newRendererGraph()
+	a, _ := g.node(ref{"a", "."})
+	s := g.svg("aa", "green", "red", map[*node]string{a: "<ab>"})
+	for _, e := range []string{
+		`<svg xmlns="http://www.w3.org/2000/svg" width="170" height="210" font-family="sans-serif" font-size="12">`,
+		`<line x1="85" y1="50" x2="85" y2="90" stroke="black" marker-end="url(#arrow)"/>`,
+		`<line x1="85" y1="50" x2="85" y2="160" stroke="black" stroke-dasharray="6,3" marker-end="url(#arrow)"/>`,
+		`<text x="85" y="105" text-anchor="middle">+1 -2</text>`,
+		`<line x1="85" y1="120" x2="85" y2="160" stroke="black" stroke-dasharray="2,3" marker-end="url(#arrow)"/>`,
+		`<g><title>&lt;ab&gt;</title><rect x="15" y="20" width="140" height="30" rx="8" fill="white" stroke="green"/><text x="85" y="35" text-anchor="middle" dominant-baseline="middle" fill="green">aa</text></g>`,
+		`<g><rect x="15" y="90" width="140" height="30" rx="8" fill="white" stroke="black"/><text x="85" y="105" text-anchor="middle" dominant-baseline="middle" fill="black">bb</text></g>`,
+		`<g><rect x="15" y="160" width="140" height="30" rx="8" fill="white" stroke="red"/><text x="85" y="175" text-anchor="middle" dominant-baseline="middle" fill="red">cc</text></g>`,
+	} {
+		if !strings.Contains(s, e+"\n") {
+			t.Error(e)
+		}
+	}
+}
@@ -3,22 +3,36 @@ package main
 import (
 	"bufio"
 	"bytes"
+	"context"
+	"errors"
 	"flag"
 	"fmt"
 	"io"
 	"log"
+	"net/url"
 	"os"
 	"os/exec"
 	"os/signal"
 	"path/filepath"
+	"sort"
 	"strings"
+	"syscall"
+	"text/template"
+	"time"
 )
 
 var (
 	bash        string
+	zsh         string
+	fish        string
+	gitComplete string
 	graphtxt    bool
 	graphdot    bool
 	graphxlib   bool
+	mermaid     bool
+	plantuml    bool
+	graphlog    bool
+	render      string
 	change      string
 	rebase      bool
 	merge       bool
@@ -30,18 +44,46 @@ var (
 	quiet       bool
 	verbose     bool
 	noop        bool
+	push        bool
+	pruneGone   bool
+	check       bool
+	atomic      bool
+	resume      bool
+	jobs        int
+	events      string
+	workspace   string
+	keepGoing   bool
+	// the same name as the option of git
+	recurseSubmodules bool
+	commitSubmodules  bool
+	exclude           patterns
+	profile           string
 )
 
 func init() {
 	log.SetFlags(0)
 	flag.StringVar(&bash, "bash", "",
 		"name of the bash function to use with complete (bash completion)")
+	flag.StringVar(&zsh, "zsh", "",
+		"name of the zsh function to use with compdef (zsh completion)")
+	flag.StringVar(&fish, "fish", "",
+		"name of the command to complete in fish (fish completion)")
+	flag.StringVar(&gitComplete, "git-completion", "",
+		"shell of the completion of git to complete greb in (git completion)")
 	flag.BoolVar(&graphtxt, "t", false,
 		"it uses a custom text format (text graph).")
 	flag.BoolVar(&graphdot, "dot", false,
 		"it uses the dot format (dot graph).")
 	flag.BoolVar(&graphxlib, "x", false,
 		"it draws the dot format in an xlib window (xlib graph).")
+	flag.BoolVar(&mermaid, "mermaid", false,
+		"it uses the mermaid flowchart format (mermaid graph).")
+	flag.BoolVar(&plantuml, "plantuml", false,
+		"it uses the plantuml format (plantuml graph).")
+	flag.BoolVar(&graphlog, "log", false,
+		"it draws the graph as git log --graph (log graph).")
+	flag.StringVar(&render, "render", "",
+		"it writes the graph in a svg, png or html file (render graph).")
 	flag.StringVar(&change, "C", "HEAD",
 		"it checks out the given branch before exit (change branch).")
 	flag.BoolVar(&rebase, "r", false,
@@ -64,6 +106,33 @@ func init() {
 		"it explains intermediate steps (verbose).")
 	flag.BoolVar(&noop, "n", false,
 		"it does not run any command (noop).")
+	flag.BoolVar(&push, "push", false,
+		"it pushes the branches after updating them (push).")
+	flag.BoolVar(&pruneGone, "prune-gone", false,
+		"it deletes merged branches whose upstreams are gone (prune gone).")
+	flag.BoolVar(&check, "check", false,
+		"it predicts the conflicts without pulling (check).")
+	flag.BoolVar(&atomic, "atomic", false,
+		"it restores the branches if anything fails (atomic).")
+	flag.BoolVar(&resume, "resume", false,
+		"it pulls the branches pending after an interrupt (resume).")
+	flag.IntVar(&jobs, "j", 1,
+		"it pulls up to N branches at the same time (jobs).")
+	flag.StringVar(&events, "events", "",
+		"it writes the events in the given format (events).")
+	flag.StringVar(&workspace, "workspace", "",
+		"it runs in every repository of the directory or list (workspace).")
+	flag.BoolVar(&keepGoing, "keep-going", false,
+		"it goes on with the other repositories after a failure (keep going).")
+	flag.BoolVar(&recurseSubmodules, "recurse-submodules", false,
+		"it runs in every submodule after the superproject (recurse).")
+	flag.BoolVar(&commitSubmodules, "commit-submodules", false,
+		"it commits the submodules that follow a branch (commit submodules).")
+	flag.Var(&exclude, "exclude",
+		"it does not pull the branches that match the pattern (exclude).")
+	flag.StringVar(&profile, "profile", "",
+		"it reads the options of the profile in git config (profile).")
+	initLongOptions()
 }
 
 func assertFlags() (err error) {
@@ -72,14 +141,22 @@ func assertFlags() (err error) {
 		value bool
 	}{
 		{"-bash (bash completion)", bash != ""},
+		{"-zsh (zsh completion)", zsh != ""},
+		{"-fish (fish completion)", fish != ""},
+		{"-git-completion (git completion)", gitComplete != ""},
 		{"-t (text graph)", graphtxt},
 		{"-dot (dot graph)", graphdot},
 		{"-x (xlib graph)", graphxlib},
+		{"-mermaid (mermaid graph)", mermaid},
+		{"-plantuml (plantuml graph)", plantuml},
+		{"-log (log graph)", graphlog},
+		{"-render (render graph)", render != ""},
 		{"-r (rebase)", rebase},
 		{"-m (merge)", merge},
 		{"-i (interactive)", interactive},
 		{"-c (checkout)", checkout},
 		{"-s (skip)", skip},
+		{"-prune-gone (prune gone)", pruneGone},
 	}
 	var found []string
 	for _, f := range flags {
@@ -91,7 +168,49 @@ func assertFlags() (err error) {
 		err = fmt.Errorf("incompatible flags: %s", strings.Join(found, ", "))
 		return
 	}
-	return
+	// they are incompatible with the graph flags, the events are written for
+	// the others too
+	others := []struct {
+		name  string
+		value bool
+	}{
+		{"-push (push)", push},
+		{"-check (check)", check},
+		{"-events (events)", events != ""},
+	}
+	for _, o := range others {
+		if !o.value {
+			continue
+		}
+		for _, f := range flags[:11] {
+			if f.value {
+				err = fmt.Errorf("incompatible flags: %s, %s", o.name, f.name)
+				return
+			}
+		}
+	}
+	for _, f := range flags[:4] {
+		if workspace != "" && f.value {
+			err = fmt.Errorf("incompatible flags: -workspace (workspace), %s",
+				f.name)
+			return
+		}
+	}
+	if workspace != "" && events != "" {
+		err = fmt.Errorf("incompatible flags: -workspace (workspace), " +
+			"-events (events)")
+		return
+	}
+	if commitSubmodules && !recurseSubmodules {
+		err = fmt.Errorf("-commit-submodules (commit submodules) requires " +
+			"-recurse-submodules (recurse)")
+		return
+	}
+	if push && check {
+		err = fmt.Errorf("incompatible flags: -push (push), -check (check)")
+		return
+	}
+	return assertRepositoryOptions()
 }
 
 var (
@@ -161,41 +280,61 @@ func getCommandColor(color bool) (command, reset string) {
 	return
 }
 
-const usage = `Usage of %[1]s [<options>] [<branches>]:
+const usage = `Usage of {{.Path}} [<options>] [<branches>]:
 
-%[2]s builds a graph of dependencies of the local branches. They usually depend
+{{.Name}} builds a graph of dependencies of the local branches. They usually depend
 on remote branches but they also can track other local branches. The graph is
 defined using the usual git config options branch.<name>.remote and
 branch.<name>.merge.
 
-%[2]s supports branches that track multiple branches at the same time. In git
+{{.Name}} supports branches that track multiple branches at the same time. In git
 there is only one value of the remote option for one or more merge options;
-%[2]s takes this into account.
+{{.Name}} takes this into account.
 
 The arguments are processed with git rev-parse to discover the abbreviated name
 of the branches. If there are no arguments, all local branches are retrieved
-instead. Then %[2]s queries recursively the remote and merge tracking options
+instead. Then {{.Name}} queries recursively the remote and merge tracking options
 to discover the dependencies and build the graph.
 
-The first set of options makes %[2]s dump the graph in the standard output in
+The first set of options makes {{.Name}} dump the graph in the standard output in
 different formats and then exit:
 
-%[3]s
-%[4]s
-%[5]s
+{{flag "t"}}
+{{flag "dot"}}
+{{flag "x"}}
+{{flag "mermaid"}}
+{{flag "plantuml"}}
+{{flag "log"}}
+{{flag "render"}}
+
+The option -render writes the graph in the file given as the first argument
+instead of printing it, the branches follow the file. The formats svg and png
+are drawn with Graphviz. If it is not installed, the svg is drawn with a simple
+layout by {{.Name}} and png is not available. The html format is a page with the
+svg embedded, hovering a branch shows the reasons, the commits ahead and behind
+every upstream branch and the subject of its last commit.
+
+The graphs also show the destination of every local branch for 'git push': the
+remote given by branch.<name>.pushRemote, remote.pushDefault or
+branch.<name>.remote and the branch given by push.default. The text graph
+annotates the local branch with the commits ahead and behind its push
+destination and the other graphs draw them in a dashed or thick edge.
 
-The second set of options makes %[2]s traverse the graph visiting the branches
+The dot graph groups the remote-tracking branches in a cluster for every remote
+labelled with its url, below the local branches.
+
+The second set of options makes {{.Name}} traverse the graph visiting the branches
 in order from the downstreams to the upstreams and running some variant of 'git
 pull' on them. If no option is provided 'git pull' merges or rebases depending
 on the usual git options.
 
-%[6]s
-%[7]s
-%[8]s
-%[9]s
-%[10]s
+{{flag "r"}}
+{{flag "m"}}
+{{flag "i"}}
+{{flag "c"}}
+{{flag "s"}}
 
-The option %[11]s makes %[2]s delete branches that don't create new history
+The option -d makes {{.Name}} delete branches that don't create new history
 over their tracking branches. None is deleted until all of them have been
 successfuly updated in the previous step. A branch is deleted if it points to
 the same commit as at least one of its upstream branches. They are deleted in
@@ -205,35 +344,245 @@ The tracking configuration is updated accordingly: if a set of branches A
 depends on a branch B, B depends on a set of branches C, and B is eligible for
 deletion: all branches A stop tracking B and start tracking the branches C.
 
-%[12]s
+{{flag "d"}}
 
-The option %[13]s makes %[2]s pull only those branches that don't have any
+The option -l makes {{.Name}} pull only those branches that don't have any
 upstream branch in a different repository from the local one.
 
-%[14]s
+{{flag "l"}}
+
+The option -exclude makes {{.Name}} leave out the local branches whose names match
+the pattern, i.e. wip/*, when no branch is given. They are still pulled if a
+branch that is pulled depends on them. It may be given many times or with a
+list of patterns separated by commas.
+
+{{flag "exclude"}}
+
+The option -push makes {{.Name}} push the local branches that have a push remote,
+branch.<name>.pushRemote or remote.pushDefault, once all of them have been
+successfully updated and deleted. The upstream branches are pushed first. A
+branch that is not a fast-forward of its remote-tracking branch for the push
+remote is pushed with --force-with-lease=<branch>:<hash>, where the hash is the
+value of the remote-tracking branch before any pull. The outcome of every push
+is reported and a failure does not stop the following ones.
+
+{{flag "push"}}
+
+The option -prune-gone makes {{.Name}} look for local branches whose upstream branches
+are configured in a remote but whose remote-tracking branches do not exist any
+more, as 'git branch -vv' shows with [gone]. They are reported and, if they are
+contained in any remote-tracking branch of the same remote other than the one
+they are pushed to, they are deleted in the same way as with the option -d.
+Their downstream branches track the default branch of the remote instead if it
+contains them, or the only remote-tracking branch that does. Otherwise a branch
+with downstream branches is not deleted. Nothing is pulled.
+
+{{flag "prune-gone"}}
 
-%[2]s checks out every branch before pulling and stops when a command doesn't
-finish with exit status 0. If all pulls finish successfully %[2]s tries to
-return to the original branch. The option %[18]s may be used to return to a
+The option -check makes {{.Name}} simulate the pulls in the same order and with
+the same variants without touching any ref or the worktree, and report the
+branches that would conflict and the conflicting files. Merges are simulated
+with 'git merge-tree' and rebases by applying the commits one by one in the
+same way. The simulated result of every branch is used for the branches that
+depend on it. The remote-tracking branches are not fetched, their current values
+are used instead.
+
+{{flag "check"}}
+
+The option -atomic makes {{.Name}} record the tips and the tracking configuration
+of the local branches before pulling anything. If any pull or deletion fails or
+is interrupted, the rebase or merge in progress is aborted, the branches are
+reset to their original commits, the tracking configuration is restored and
+GREB_HEAD is checked out. The pushes of -push are not rolled back.
+
+{{flag "atomic"}}
+
+If {{.Name}} is interrupted with Ctrl-C, it does not pull more branches, it
+interrupts the running git command right away and kills it if it has not
+exited after 10 seconds, it records the branches that have not been pulled yet
+in the file GREB_RESUME of the git directory and it returns to the original
+branch or the one given with -C. The option -resume makes {{.Name}} pull
+those branches instead of the arguments, the file is removed after a successful
+run.
+
+{{flag "resume"}}
+
+The option -j makes {{.Name}} pull up to N branches at the same time. Every
+remote is fetched once first, then a branch is merged or rebased as soon as all
+its upstream branches have been pulled, in a temporary linked worktree created
+with 'git worktree add'. The output of every branch is buffered and printed in
+order. If a pull fails, no more branches are pulled and its worktree is kept to
+resolve the conflicts, unless the option -atomic is given. The current branch is
+detached first because it cannot be checked out in another worktree. It is
+incompatible with the options -i and -c.
+
+{{flag "j"}}
+
+The option -events makes {{.Name}} write one line for every event in the given
+format, json is the only one. They are written in the standard output, the
+output of git is moved to the standard error then, or in the file descriptor N
+given with json:N. Every event is an object with the fields "event" and "time"
+and the following ones depending on the event:
+
+  graph:    The graph has been built: "branches".
+  branch:   A branch is going to be pulled: "branch".
+  command:  A git command that changes the repository has finished: "argv",
+            "duration" in seconds and "status". The commands that only read
+            it, i.e. rev-parse or for-each-ref, do not have events.
+  updated:  A branch has been pulled: "branch", "old" and "new" hashes.
+  deleted:  A branch has been deleted: "branch", "old" hash.
+  config:   The tracking configuration has changed: "branch", "key", "value"
+            and "action": add, unset or set.
+  finished: The run has finished: "error" if it failed.
+
+{{flag "events"}}
+
+At the end {{.Name}} prints a summary with the action taken on every branch that
+has been visited: fast-forwarded, merged, rebased, up to date, skipped by -l,
+checked out, failed or deleted, the old and new commits and the number of
+commits gained.
+
+{{flag "workspace"}}
+{{flag "keep-going"}}
+
+The option -workspace makes {{.Name}} run with the rest of the options and the
+arguments in every git repository of the directory, or its subdirectories, or
+in the repositories listed in the file, one in every line relative to the file.
+The output of every repository is buffered and printed in order followed by a
+table with the result of every repository. The option -j runs up to N
+repositories at the same time instead of branches. No more repositories are
+run after a failure unless the option -keep-going is given.
+
+{{flag "recurse-submodules"}}
+{{flag "commit-submodules"}}
+
+The option -recurse-submodules makes {{.Name}} run with the same options, but -C, in every
+initialized submodule once the superproject has been successfully updated, and
+in their submodules in turn. Every submodule graph is built from its own
+branches, with -render it is written in the file with the path of the submodule
+added before the extension, i.e. graph-lib-foo.svg for graph.svg and lib/foo.
+The option -commit-submodules commits every submodule that has moved in every
+local branch of the superproject whose .gitmodules gives the branch checked out
+in the submodule as submodule.<name>.branch, the other branches are checked out
+to commit and the current one is checked out again.
+
+{{.Name}} checks out every branch before pulling and stops when a command doesn't
+finish with exit status 0. If all pulls finish successfully {{.Name}} tries to
+return to the original branch. The option -C may be used to return to a
 different one. As the branch may have been deleted or any command may have
 failed, the user should expect that the current branch may be different or even
-that the HEAD may become detached. %[2]s tries to minimize the number of
+that the HEAD may become detached. {{.Name}} tries to minimize the number of
 checkouts.
 
-%[19]s
+{{flag "C"}}
+{{flag "zsh"}}
+{{flag "fish"}}
+
+The completion scripts complete the options, the subcommands with their own
+options and the local branches for the arguments and the options that take a
+branch. The bash
+function is used with 'complete -F <name> {{.Name}}', the zsh one with
+'compdef <name> {{.Name}}' and the fish commands are sourced.
+
+{{flag "git-completion"}}
+
+The option -git-completion prints instead the function that the completion of git
+calls to complete 'git greb': _git_greb for bash, that uses the functions of
+git-completion.bash and works with git-completion.zsh too, or _git-greb for the
+_git function of zsh. It must be sourced after the completion of git.
+
+{{.Name}} also accepts the following subcommands instead of a list of branches.
+A branch with the same name as a subcommand may be given as refs/heads/<name>.
+
+  rename <old> <new>: It renames the branch <old> and updates the tracking
+                      configuration of the branches that depend on it.
+  move <branch> -onto <upstream>:
+                      It rebases the branch from its only upstream branch onto
+                      a different one, makes it track the new one and then
+                      rebases all the branches that depend on it onto the
+                      new commits of their upstream branches.
+  split <branch> <commit> [-b <name>]:
+                      It creates a new branch at the given commit that tracks
+                      the upstream branches of <branch> and makes <branch>
+                      track it. The name is <branch>-base by default.
+  fold <branch>:      It pulls all the branches that depend on the branch so
+                      that they include its commits, makes them track its
+                      upstream branches and then deletes it if all of them
+                      contain it. A branch without downstream branches is not
+                      folded and the options -c, -s, -l and -n are not
+                      allowed because they do not pull.
+  tui:                It shows the graph in the whole terminal. The arrows or
+                      j and k select a branch and the following keys act on
+                      it: c checks it out, u pulls it and the branches that
+                      depend on it, d deletes it if it is merged, a and r add
+                      or remove an upstream branch, D shows the diff with its
+                      upstream branches and q quits.
+  watch [-fetch <interval>]:
+                      It waits for changes of the refs, with inotify on Linux,
+                      and updates the branches that depend on a branch that
+                      moves without checking them out: it fast-forwards,
+                      rebases or merges them as 'git pull' and prints them.
+                      The branches checked out in any worktree and the ones
+                      that would conflict are not updated. The remotes are
+                      fetched at the given interval, i.e. 5m, if any.
+
+The following subcommands do the same as the options above, each one with its
+own options, -h prints them, and only those are read from git config. The
+options given before the subcommand are kept, but the ones that select what {{.Name}}
+does, i.e. -r or -push, must be options of the subcommand.
+
+  graph [-format text|log|xlib]:
+                      It prints the graph as the options -t, -log and -x.
+  update [<options>]: It pulls the branches with the second set of options and
+                      the ones of the workspaces and the submodules.
+  delete [-gone]:     It deletes the merged branches without pulling them as
+                      -s -d, or the ones whose upstreams are gone as
+                      -prune-gone.
+  status [<options>]: It predicts the conflicts as -check.
+  export [-format <format>] [-o <file>]:
+                      It writes the graph in the standard output or in the
+                      file in the formats dot, mermaid, plantuml, svg, png or
+                      html as the options -dot, -mermaid, -plantuml and
+                      -render.
+  completion [-git] <shell> [<name>]:
+                      It prints the completion for bash, zsh or fish as the
+                      options -bash, -zsh, -fish and -git-completion. The name
+                      is _{{.Name}} or {{.Name}} for fish by default.
+  help [<subcommand>]:
+                      It prints the help of the subcommand or this one.
+
+The options may be given with one or two dashes and their values after = or in
+the next argument, i.e. --jobs=4 or -j 4. The single letter options may be
+grouped, i.e. -qv or -j4. They have the following long names:
+
+{{longOptions}}
 
 Other options:
 
-%[15]s
-%[16]s
-%[17]s
-%[20]s
+{{flag "q"}}
+{{flag "v"}}
+{{flag "n"}}
+{{flag "bash"}}
+
+{{flag "profile"}}
 
-%[2]s checks the following options in the usual git configuration files:
+{{.Name}} checks the following options in the usual git configuration files:
 
-  greb.local:         If the option -l is false, this bool option is used
-                      instead.
-  color.greb:         It enables or disables color in %[2]s. See color.ui for
+  greb.<option>:      Every option of the command line by its long name, i.e.
+                      greb.rebase true or greb.jobs 4, is used if it is not
+                      given in the command line. A bool option without value
+                      is true. The multiple values of -exclude are added. The
+                      single letter names, which git does not tell apart from
+                      their capital letters, and the unknown options are
+                      ignored with a warning.
+  greb.profile:       The profile that is used if -profile is not given.
+  greb.profile.<name>.<option>:
+                      The options of the profile given with -profile, they
+                      override the ones of greb.<option>. The option mode is
+                      one of rebase, merge, interactive, checkout or skip and
+                      it replaces the one of greb.<option> unless the command
+                      line gives any. A profile without options is an error.
+  color.greb:         It enables or disables color in {{.Name}}. See color.ui for
                       more information.
   color.greb.command: The color of the git commands that the user needs to know
                       that have been run. Blue by default.
@@ -242,85 +591,183 @@ Other options:
                       default.
 `
 
-func main() {
-	flag.Usage = func() {
-		f := func(name string) string {
+// it prints usage with the name of the command and the lines of the options
+func printUsage() {
+	t := template.Must(template.New("usage").Funcs(template.FuncMap{
+		"flag": func(name string) string {
 			f := flag.Lookup(name)
-			format := "  %5s=%s: %s"
-			return fmt.Sprintf(format, "-"+name, f.DefValue, f.Usage)
-		}
-		fmt.Fprintf(os.Stderr, usage, os.Args[0], filepath.Base(os.Args[0]),
-			f("t"), f("dot"), f("x"),
-			f("r"), f("m"), f("i"), f("c"), f("s"),
-			"-d", f("d"),
-			"-l", f("l"),
-			f("q"), f("v"), f("n"),
-			"-C", f("C"),
-			f("bash"),
-		)
-	}
-	flag.Parse()
+			return fmt.Sprintf("  %5s=%s: %s", "-"+name, f.DefValue, f.Usage)
+		},
+		"longOptions": formatLongOptions,
+	}).Parse(usage))
+	t.Execute(flag.CommandLine.Output(), struct{ Path, Name string }{
+		os.Args[0], filepath.Base(os.Args[0])})
+}
+
+func main() {
+	flag.Usage = printUsage
+	flag.CommandLine.Parse(expandOptions(flag.CommandLine, os.Args[1:]))
+	// they read the options of their subcommands first
+	if _, ok := optionCommands[flag.Arg(0)]; !ok || workspace != "" {
+		if err := initOptions(flag.CommandLine); err != nil {
+			logFatal(err)
+		}
+		if err := assertFlags(); err != nil {
+			logFatal(err)
+		}
+	}
 	initColors()
-	updateFlagsWithOptions()
-	if err := assertFlags(); err != nil {
-		logFatal(err)
+	if bash != "" || zsh != "" || fish != "" || gitComplete != "" {
+		if err := printCompletion(); err != nil {
+			logFatal(err)
+		}
+	} else if workspace != "" {
+		if err := grebWorkspace(flag.Args()); err != nil {
+			logFatal(err)
+		}
+	} else if c, ok := subcommands[flag.Arg(0)]; ok {
+		err := c(flag.Args()[1:])
+		if errors.Is(err, flag.ErrHelp) {
+			err = nil
+		}
+		emitFinishedEvent(err)
+		if err != nil {
+			logFatal(err)
+		}
+	} else {
+		err := runGreb(flag.Args())
+		emitFinishedEvent(err)
+		if err != nil {
+			logFatal(err)
+		}
 	}
+}
+
+// it prints the completion script given by the options
+func printCompletion() (err error) {
 	if bash != "" {
 		fmt.Println(bashCompletion(bash))
-	} else if err := greb(flag.Args()); err != nil {
-		logFatal(err)
+	} else if zsh != "" {
+		fmt.Println(zshCompletion(zsh))
+	} else if fish != "" {
+		fmt.Println(fishCompletion(fish))
+	} else {
+		// git completes git-<name> as git <name>
+		name := strings.TrimPrefix(filepath.Base(os.Args[0]), "git-")
+		switch gitComplete {
+		case "bash":
+			fmt.Println(gitBashCompletion("_git_" + name))
+		case "zsh":
+			fmt.Println(zshCompletion("_git-" + name))
+		default:
+			err = fmt.Errorf("invalid shell: %s", gitComplete)
+		}
 	}
+	return
 }
 
-func bashCompletion(funcname string) string {
-	return fmt.Sprintf(`%s() {
-	local cur=${COMP_WORDS[COMP_CWORD]}
-	if [ $COMP_CWORD -gt 1 ]; then
-		local prev=${COMP_WORDS[$(($COMP_CWORD-1))]}
-		case $prev in
-			-C|--C)
-				local branches=$(git for-each-ref refs/heads --format '%%(refname:short)' -s)
-				COMPREPLY=( $(compgen -W "$branches" -- "$cur") )
-				return
-				;;
-			-bash|--bash)
-				COMPREPLY=()
-				return
-				;;
-		esac
-	fi
-	case $cur in
-		--*)
-			local opts="--bash --t --dot --x --C --r --m --i --c --s --d --l --q --v --n"
-			COMPREPLY=( $(compgen -W "$opts" -- "$cur") )
-			return
-			;;
-	esac
-	local opts="-bash -t -dot -x -C -r -m -i -c -s -d -l -q -v -n"
-	COMPREPLY=( $(compgen -W "$opts" -- "$cur") )
-}`, funcname)
+// it runs greb in the repositories of the workspace or in the current one and
+// its submodules
+func runGreb(branches []string) (err error) {
+	if workspace != "" {
+		return grebWorkspace(branches)
+	}
+	if err = greb(branches); err == nil && recurseSubmodules {
+		err = updateSubmodules(branches)
+	}
+	return
 }
 
-func updateFlagsWithOptions() {
-	if !local {
-		cmd := newCommand(verbose, false, "git", "config", "--bool", "greb.local")
-		if output, err := cmd.CombinedOutput(); err != nil {
-			if verbose {
-				logPrintf("-> no config\n")
-			}
-		} else {
-			l := strings.TrimSpace(string(output))
-			if verbose {
-				logPrintf("-> %s\n", l)
-			}
-			local = l == "true"
+var subcommands = map[string]func(args []string) error{
+	"rename": renameBranch,
+	"move":   moveBranch,
+	"split":  splitBranch,
+	"fold":   foldBranch,
+	"tui":    tuiGraph,
+	"watch":  watchBranches,
+}
+
+// it parses the flags of a subcommand, they may appear after the arguments but
+// not after --
+func parseSubcommand(fs *flag.FlagSet, args []string) (rest []string, err error) {
+	if collectFlagSet != nil {
+		collectFlagSet(fs)
+		err = flag.ErrHelp
+		return
+	}
+	for {
+		expanded := expandOptions(fs, args)
+		if err = fs.Parse(expanded); err != nil {
+			return
 		}
+		args = fs.Args()
+		if endsFlags(fs, expanded[:len(expanded)-len(args)]) {
+			rest = append(rest, args...)
+			return
+		}
+		if len(args) == 0 {
+			return
+		}
+		rest, args = append(rest, args[0]), args[1:]
 	}
 }
 
+// whether the last of the parsed arguments is the -- that ends the flags and
+// not the value of the flag before it
+func endsFlags(fs *flag.FlagSet, parsed []string) bool {
+	l := len(parsed)
+	if l == 0 || parsed[l-1] != "--" {
+		return false
+	}
+	if l == 1 {
+		return true
+	}
+	prev := parsed[l-2]
+	if !strings.HasPrefix(prev, "-") || strings.Contains(prev, "=") {
+		return true
+	}
+	f := fs.Lookup(strings.TrimLeft(prev, "-"))
+	return f == nil || isBoolFlag(f)
+}
+
+// it completes the options of the flag set with the ones of git config and
+// starts writing the events
+func initOptions(fs *flag.FlagSet) (err error) {
+	if err = updateFlagsWithOptions(fs); err != nil {
+		return
+	}
+	return initEvents()
+}
+
 func greb(branches []string) (err error) {
+	var file string
+	if render != "" {
+		if len(branches) == 0 {
+			err = fmt.Errorf("missing file to render")
+			return
+		}
+		file, branches = branches[0], branches[1:]
+	}
+	if resume {
+		if branches, err = readResume(); err != nil {
+			return
+		}
+	}
 	var g *graph
-	if len(branches) == 0 {
+	if len(branches) == 0 && len(exclude) > 0 {
+		var all []string
+		if all, err = getAllBranches(); err != nil {
+			return
+		}
+		for _, b := range all {
+			if !exclude.match(b) {
+				branches = append(branches, b)
+			}
+		}
+		if g, err = fillGraphForBranches(branches); err != nil {
+			return
+		}
+	} else if len(branches) == 0 {
 		if g, err = fillGraphForAllBranches(); err != nil {
 			return
 		}
@@ -329,14 +776,36 @@ func greb(branches []string) (err error) {
 			return
 		}
 	}
+	emitGraphEvent(g)
 	fullcurrent, current, _ := getSymbolicFullNames("HEAD")
+	if graphtxt || graphdot || graphxlib || mermaid || plantuml || graphlog ||
+		render != "" {
+		fillPushTargets(g)
+	}
+	if graphdot || graphxlib || render != "" {
+		fillRemoteURLs(g)
+	}
 	if graphtxt {
-		fmt.Print(g.text(nil, "", "  ", current, currentColorCode, remoteColorCode,
-			resetColorCode))
+		fmt.Fprint(graphOutput, g.text(nil, "", "  ", current, currentColorCode,
+			remoteColorCode, resetColorCode))
 		return
 	} else if graphdot {
-		fmt.Print(g.dot(current, currentColorName, remoteColorName))
+		fmt.Fprint(graphOutput, g.dot(current, currentColorName, remoteColorName))
 		return
+	} else if graphlog {
+		fmt.Fprint(graphOutput, g.log(current, currentColorCode, remoteColorCode,
+			resetColorCode))
+		return
+	} else if mermaid {
+		fmt.Fprint(graphOutput, g.mermaid(current, currentColorName,
+			remoteColorName))
+		return
+	} else if plantuml {
+		fmt.Fprint(graphOutput, g.plantuml(current, currentColorName,
+			remoteColorName))
+		return
+	} else if render != "" {
+		return renderGraph(g, current, render, file)
 	} else if graphxlib {
 		cmd := newCommand(!quiet, true, "dot", "-Txlib")
 		if !noop {
@@ -351,41 +820,88 @@ func greb(branches []string) (err error) {
 		}
 		return
 	}
+	// it is read-only, neither GREB_HEAD nor the current branch change
+	if check {
+		return checkBranches(g)
+	}
 	updateGrebHeadRef(fullcurrent)
 	_, branch, _ := getSymbolicFullNames(change)
-	s := make(chan os.Signal, 1)
-	signal.Notify(s, os.Interrupt)
+	var snap *snapshot
+	var pending []*node
+	// the branches are pulled when the pushes fail
+	var pushing bool
+	if atomic {
+		if snap, err = takeSnapshot(g); err != nil {
+			return
+		}
+	}
+	restore, stop := notifyInterrupt()
 	defer func() {
-		signal.Stop(s)
-		// There is a race condition: the signal may not be sent to the channel
-		// before we reach this point. The channel cannot be used.
-		if err != nil {
-			// The string comparision is ugly, but the race condition is too much
-			// uncertain.
-			if !strings.HasSuffix(err.Error(), "signal: interrupt") {
-				return
+		// another interrupt must not stop the cleanup
+		defer stop()
+		interrupted := isInterrupted(err)
+		// the context is not valid to restore or checkout anymore
+		restore()
+		if err != nil && snap != nil {
+			if rerr := snap.restore(); rerr != nil {
+				logPrintf("rollback: %s\n", rerr)
 			}
+			return
+		}
+		if !noop {
+			fmt.Fprint(commandOutput, formatResults(results))
+		}
+		if err != nil && !interrupted && !pushing {
+			return
+		}
+		if interrupted {
+			writeResume(pending)
 		}
 		if branch != "" {
-			checkoutBranchIfNeeded(branch, &current)
+			if cerr := checkoutBranchIfNeeded(branch, &current); cerr != nil {
+				logPrintf("%s\n", cerr)
+			}
 		}
 	}()
+	if pruneGone {
+		return pruneGoneBranches(g, &branch, &current)
+	}
 	sort := g.sort()
+	var targets map[string]pushTarget
+	if push {
+		targets = getPushTargets(g)
+	}
 	if !skip {
-		for _, n := range sort {
-			if err = pullBranch(n, &current); err != nil {
-				return
-			}
+		if jobs > 1 {
+			pending, err = pullBranchesInParallel(sort, &current)
+		} else {
+			pending, err = pullBranches(sort, &current)
+		}
+		if err != nil {
+			return
 		}
 	}
 	if remove {
 		for i := len(sort) - 1; i >= 0; i-- {
+			if ctx.Err() != nil {
+				err = errInterrupted
+				return
+			}
 			n := sort[i]
 			if err = deleteBranchIfMerged(g, n, &branch, &current); err != nil {
 				return
 			}
 		}
 	}
+	if err = removeResume(); err != nil {
+		return
+	}
+	if push {
+		// the pushes cannot be rolled back
+		snap = nil
+		pushing = true
+		err = pushBranches(g, targets)
+	}
 	return
 }
 
@@ -395,6 +911,15 @@ const (
 )
 
 func fillGraphForAllBranches() (g *graph, err error) {
+	var branches []string
+	if branches, err = getAllBranches(); err != nil {
+		return
+	}
+	return fillGraphForBranches(branches)
+}
+
+// the full names of the local branches
+func getAllBranches() (branches []string, err error) {
 	cmd := newCommand(verbose, false, "git", "for-each-ref", refsHeads,
 		"--format", "%(refname)")
 	var outpipe io.ReadCloser
@@ -407,7 +932,6 @@ func fillGraphForAllBranches() (g *graph, err error) {
 		return
 	}
 	scanner := bufio.NewScanner(outpipe)
-	var branches []string
 	for scanner.Scan() {
 		branches = append(branches, scanner.Text())
 	}
@@ -416,9 +940,8 @@ func fillGraphForAllBranches() (g *graph, err error) {
 	}
 	if err = cmd.Wait(); err != nil {
 		err = cmdError(cmd, err)
-		return
 	}
-	return fillGraphForBranches(branches)
+	return
 }
 
 func fillGraphForBranches(branches []string) (g *graph, err error) {
@@ -468,6 +991,9 @@ func fillGraphForBranches(branches []string) (g *graph, err error) {
 				if ok {
 					g.edge(n, u, r)
 				} else if rn, err := findRemoteTrackingBranch(u.ref); err != nil {
+					if _, ok := err.(goneError); ok {
+						n.gone = append(n.gone, r)
+					}
 					err = nil
 					delete(g.nodes, u.ref)
 				} else if !strings.HasPrefix(rn, refsRemotes) {
@@ -482,6 +1008,37 @@ func fillGraphForBranches(branches []string) (g *graph, err error) {
 	return
 }
 
+// it adds the urls of the remotes of the graph, they label the dot clusters
+func fillRemoteURLs(g *graph) {
+	g.urls = make(map[string]string)
+	for r := range g.nodes {
+		if _, ok := g.urls[r.remote]; ok || r.remote == "." {
+			continue
+		}
+		cmd := newCommand(verbose, false, "git", "config",
+			"remote."+r.remote+".url")
+		if output, err := cmd.CombinedOutput(); err != nil {
+			if verbose {
+				logPrintf("-> no config\n")
+			}
+		} else {
+			g.urls[r.remote] = stripUserinfo(strings.TrimSpace(string(output)))
+			if verbose {
+				logPrintf("-> %s\n", g.urls[r.remote])
+			}
+		}
+	}
+}
+
+// the url without the user and password, they are not shown in the graph
+func stripUserinfo(remoteURL string) string {
+	if u, err := url.Parse(remoteURL); err == nil && u.User != nil {
+		u.User = nil
+		return u.String()
+	}
+	return remoteURL
+}
+
 func getTrackingInfo(branch string) (remote string, refnames []string, err error) {
 	cmd := newCommand(verbose, false, "git", "config", "branch."+branch+".remote")
 	var output []byte
@@ -527,9 +1084,58 @@ func getTrackingInfo(branch string) (remote string, refnames []string, err error
 }
 
 func findRemoteTrackingBranch(r ref) (refname string, err error) {
+	var fetchspecs [][2]string
+	if fetchspecs, err = getFetchspecs(r.remote); err != nil {
+		return
+	}
+	for _, s := range fetchspecs {
+		f, l := s[0], s[1]
+		if strings.HasPrefix(r.name, f) {
+			b := l + r.name[len(f):]
+			var fn string
+			if fn, _, err = getSymbolicFullNames(b); err != nil {
+				err = goneError{r}
+				return
+			}
+			refname = fn
+			return
+		}
+	}
+	err = fmt.Errorf("remote %v does not fetch ref %v", r.remote, r.name)
+	return
+}
+
+// the ref in the remote that the remote-tracking branch is fetched from
+func findRemoteRef(refname string) (r ref, err error) {
+	cmd := newCommand(verbose, false, "git", "remote")
+	var output []byte
+	if output, err = cmd.Output(); err != nil {
+		err = cmdError(cmd, err)
+		return
+	}
+	for _, remote := range strings.Fields(string(output)) {
+		var fetchspecs [][2]string
+		if fetchspecs, err = getFetchspecs(remote); err != nil {
+			return
+		}
+		for _, s := range fetchspecs {
+			f, l := s[0], s[1]
+			if strings.HasPrefix(refname, l) {
+				r = ref{f + refname[len(l):], remote}
+				return
+			}
+		}
+	}
+	err = fmt.Errorf("no remote fetches %s", refname)
+	return
+}
+
+// the sources and destinations of the fetchspecs of the remote, without the
+// trailing * of the patterns
+func getFetchspecs(remote string) (fetchspecs [][2]string, err error) {
 	// there is no git command to retrieve it, remote.<remote>.fetch is parsed
 	cmd := newCommand(verbose, false, "git", "config", "--get-all",
-		"remote."+r.remote+".fetch")
+		"remote."+remote+".fetch")
 	var outpipe io.ReadCloser
 	if outpipe, err = cmd.StdoutPipe(); err != nil {
 		err = cmdError(cmd, err)
@@ -540,18 +1146,18 @@ func findRemoteTrackingBranch(r ref) (refname string, err error) {
 		return
 	}
 	scanner := bufio.NewScanner(outpipe)
-	var fetchspecs []string
+	var lines []string
 	for scanner.Scan() {
-		fetchspecs = append(fetchspecs, scanner.Text())
+		lines = append(lines, scanner.Text())
 	}
 	if verbose {
-		logPrintf("-> %s\n", strings.Join(fetchspecs, ", "))
+		logPrintf("-> %s\n", strings.Join(lines, ", "))
 	}
 	if err = cmd.Wait(); err != nil {
 		err = cmdError(cmd, err)
 		return
 	}
-	for _, s := range fetchspecs {
+	for _, s := range lines {
 		if strings.HasPrefix(s, "+") {
 			s = s[1:]
 		}
@@ -560,20 +1166,21 @@ func findRemoteTrackingBranch(r ref) (refname string, err error) {
 		if strings.HasSuffix(f, "*") && strings.HasSuffix(l, "*") {
 			f, l = f[:len(f)-1], l[:len(l)-1]
 		}
-		if strings.HasPrefix(r.name, f) {
-			b := l + r.name[len(f):]
-			var fn string
-			if fn, _, err = getSymbolicFullNames(b); err != nil {
-				return
-			}
-			refname = fn
-			return
-		}
+		fetchspecs = append(fetchspecs, [2]string{f, l})
 	}
-	err = fmt.Errorf("remote %v does not fetch ref %v", r.remote, r.name)
 	return
 }
 
+// the remote fetches the ref but the remote-tracking branch does not exist
+type goneError struct {
+	ref
+}
+
+func (e goneError) Error() string {
+	return fmt.Sprintf("remote-tracking branch of ref %v in remote %v is gone",
+		e.name, e.remote)
+}
+
 func getSymbolicFullNames(refname string) (fullname, shortname string, err error) {
 	cmd := newCommand(verbose, false, "git", "rev-parse", "--symbolic-full-name", refname)
 	var output []byte
@@ -605,32 +1212,92 @@ func updateGrebHeadRef(current string) (err error) {
 	} else {
 		cmd = newCommand(verbose, false, "git", "symbolic-ref", "GREB_HEAD", current)
 	}
-	if !noop {
-		cmd.Stdout = os.Stdout
-		cmd.Stderr = os.Stderr
-		if err = cmd.Run(); err != nil {
-			err = cmdError(cmd, err)
+	if err = runCommand(cmd); err != nil {
+		return
+	}
+	return
+}
+
+var errInterrupted = errors.New("interrupted")
+
+// it pulls the nodes in order until one of them fails or the context is done,
+// it returns the nodes that have not been pulled completely
+func pullBranches(nodes []*node, current *string) (pending []*node, err error) {
+	for i, n := range nodes {
+		if ctx.Err() != nil {
+			err = errInterrupted
+		} else {
+			err = pullBranch(n, current)
+		}
+		if err != nil {
+			pending = nodes[i:]
 			return
 		}
 	}
 	return
 }
 
-func pullBranch(n *node, current *string) (err error) {
-	if local {
-		for u := range n.upstreams {
-			if u.remote != "." {
-				return
-			}
+// whether the error comes from an interrupt: the context is done or a command
+// has been killed by SIGINT
+func isInterrupted(err error) bool {
+	if err == nil {
+		return false
+	}
+	if ctx.Err() != nil || errors.Is(err, errInterrupted) ||
+		errors.Is(err, context.Canceled) {
+		return true
+	}
+	var e *exec.ExitError
+	if errors.As(err, &e) {
+		if s, ok := e.Sys().(syscall.WaitStatus); ok && s.Signaled() {
+			return s.Signal() == syscall.SIGINT
 		}
+		// the exit status of the shells
+		return e.ExitCode() == 128+int(syscall.SIGINT)
 	}
+	return false
+}
+
+func pullBranch(n *node, current *string) (err error) {
+	if local && hasRemoteUpstreams(n) {
+		recordResult(result{branch: n.branch, action: "skipped by -l"})
+		return
+	}
+	emitEvent(event{Event: "branch", Branch: n.branch})
 	if err = checkoutBranchIfNeeded(n.branch, current); err != nil {
 		return
 	}
-	args := []string{"pull"}
+	old, _ := revParse(n.branch)
 	if checkout {
+		recordResult(result{branch: n.branch, action: "checked out", old: old,
+			head: old})
+		return
+	}
+	cmd := newCommand(!quiet, true, "git", pullArgs(n)...)
+	cmd.Stdin = os.Stdin
+	if err = runCommand(cmd); err != nil {
+		recordResult(result{branch: n.branch, action: "failed", old: old})
 		return
-	} else if rebase {
+	}
+	hash, _ := revParse(n.branch)
+	emitEvent(event{Event: "updated", Branch: n.branch, Old: old, New: hash})
+	recordPull(n.branch, old, hash)
+	return
+}
+
+func hasRemoteUpstreams(n *node) bool {
+	for u := range n.upstreams {
+		if u.remote != "." {
+			return true
+		}
+	}
+	return false
+}
+
+// the arguments of git to pull the branch once it is checked out
+func pullArgs(n *node) (args []string) {
+	args = []string{"pull"}
+	if rebase {
 		args = append(args, "--rebase")
 	} else if merge {
 		args = append(args, "--no-rebase")
@@ -639,15 +1306,107 @@ func pullBranch(n *node, current *string) (err error) {
 	} else if len(n.upstreams) > 1 {
 		args = append(args, "--no-rebase")
 	}
-	cmd := newCommand(!quiet, true, "git", args...)
-	if !noop {
-		cmd.Stdin = os.Stdin
-		cmd.Stdout = os.Stdout
-		cmd.Stderr = os.Stderr
-		if err = cmd.Run(); err != nil {
-			err = cmdError(cmd, err)
+	return
+}
+
+func pruneGoneBranches(g *graph, branch, current *string) (err error) {
+	var nodes nodesort
+	for _, n := range g.nodes {
+		if len(n.gone) > 0 {
+			nodes = append(nodes, n)
+		}
+	}
+	sort.Sort(&nodes)
+	fillPushTargets(g)
+	for _, n := range nodes {
+		var push, remote string
+		if n.push != nil {
+			push = refsRemotes + n.push.branch
+		}
+		if remote, _, err = getTrackingInfo(n.branch); err != nil {
 			return
 		}
+		var contains []string
+		var head string
+		if contains, head, err = getRemoteContains(n.branch, remote,
+			push); err != nil {
+			return
+		}
+		gone := strings.Join(n.gone, ", ")
+		if len(contains) == 0 {
+			logPrintf("%s: upstream %s is gone, not merged\n", n.branch, gone)
+			continue
+		}
+		// the default branch of the remote or the only one
+		if head == "" && len(contains) == 1 {
+			head = contains[0]
+		}
+		var short []string
+		for _, c := range contains {
+			short = append(short, strings.TrimPrefix(c, refsRemotes))
+		}
+		if head == "" && len(n.downstreams) > 0 {
+			logPrintf("%s: upstream %s is gone, merged into %s, the downstreams "+
+				"would not know which one to track, not deleted\n", n.branch, gone,
+				strings.Join(short, ", "))
+			continue
+		}
+		if head != "" {
+			short = []string{strings.TrimPrefix(head, refsRemotes)}
+		}
+		logPrintf("%s: upstream %s is gone, merged into %s\n", n.branch, gone,
+			strings.Join(short, ", "))
+		if head != "" {
+			// the downstreams follow the branch it is merged into
+			var r ref
+			if r, err = findRemoteRef(head); err != nil {
+				return
+			}
+			u, _ := g.node(r)
+			u.branch = head[len(refsRemotes):]
+			g.edge(n, u, r.name)
+		}
+		if err = deleteBranch(g, n, branch, current); err != nil {
+			return
+		}
+	}
+	return
+}
+
+// the remote-tracking branches of the remote that contain the branch other
+// than the one it is pushed to, and the one of the default branch of the
+// remote if it is any of them
+func getRemoteContains(branch, remote, push string) (refnames []string,
+	head string, err error) {
+	var fetchspecs [][2]string
+	if fetchspecs, err = getFetchspecs(remote); err != nil {
+		return
+	}
+	if len(fetchspecs) == 0 {
+		return
+	}
+	args := []string{"for-each-ref", "--contains", branch, "--format",
+		"%(refname) %(symref)"}
+	for _, f := range fetchspecs {
+		args = append(args, f[1])
+	}
+	cmd := newCommand(verbose, false, "git", args...)
+	var output []byte
+	if output, err = cmd.Output(); err != nil {
+		err = cmdError(cmd, err)
+		return
+	}
+	for _, line := range strings.Split(string(output), "\n") {
+		// <remote>/HEAD is a symbolic ref to the default branch
+		name, symref, _ := strings.Cut(line, " ")
+		if symref != "" && symref != push {
+			head = symref
+		} else if name != "" && name != push && symref == "" {
+			refnames = append(refnames, name)
+		}
+	}
+	if verbose {
+		logPrintf("-> %s\n", strings.Join(refnames, ", "))
 	}
 	return
 }
@@ -669,6 +1428,27 @@ func deleteBranchIfMerged(g *graph, n *node, branch, current *string) (err error
 	return
 }
 
+// commits in branch that are not in other and vice versa
+func getAheadBehind(branch, other string) (ahead, behind int, err error) {
+	cmd := newCommand(verbose, false, "git", "rev-list", "--left-right",
+		"--count", branch+"..."+other)
+	var output []byte
+	if output, err = cmd.CombinedOutput(); err != nil {
+		err = cmdError(cmd, err)
+		if verbose {
+			logPrintf("-> no count\n")
+		}
+		return
+	}
+	if _, err = fmt.Sscan(string(output), &ahead, &behind); err != nil {
+		return
+	}
+	if verbose {
+		logPrintf("-> %d %d\n", ahead, behind)
+	}
+	return
+}
+
 func revParse(branch string) (hash string, err error) {
 	cmd := newCommand(verbose, false, "git", "rev-parse", "-q", "--verify",
 		branch)
@@ -696,52 +1476,266 @@ func deleteBranch(g *graph, n *node, branch, current *string) (err error) {
 			return
 		}
 	}
+	hash, _ := revParse(n.branch)
 	cmd := newCommand(!quiet, true, "git", "branch", "-D", n.branch)
-	if !noop {
-		cmd.Stdout = os.Stdout
-		cmd.Stderr = os.Stderr
-		if err = cmd.Run(); err != nil {
-			err = cmdError(cmd, err)
-			return
-		}
+	if err = runCommand(cmd); err != nil {
+		return
 	}
-	for _, update := range g.remove(n) {
+	emitEvent(event{Event: "deleted", Branch: n.branch, Old: hash})
+	recordResult(result{branch: n.branch, action: "deleted", old: hash})
+	return applyUpdates(g.remove(n))
+}
+
+// it runs the git config commands for instances of addUpstream, rmUpstream,
+// setRemote
+func applyUpdates(updates []interface{}) (err error) {
+	for _, update := range updates {
+		var cmd *exec.Cmd
+		var e event
 		switch u := update.(type) {
 		case rmUpstream:
 			cmd = newCommand(!quiet, true, "git", "config", "--unset",
 				"branch."+u.downstream+".merge", "^"+u.upstream+"$")
-			if !noop {
-				cmd.Stdout = os.Stdout
-				cmd.Stderr = os.Stderr
-				if err = cmd.Run(); err != nil {
-					err = cmdError(cmd, err)
-					return
-				}
-			}
+			e = event{Branch: u.downstream, Key: "merge", Value: u.upstream,
+				Action: "unset"}
 		case addUpstream:
 			cmd = newCommand(!quiet, true, "git", "config", "--add",
 				"branch."+u.downstream+".merge", u.upstream)
-			if !noop {
-				cmd.Stdout = os.Stdout
-				cmd.Stderr = os.Stderr
-				if err = cmd.Run(); err != nil {
-					err = cmdError(cmd, err)
-					return
-				}
-			}
+			e = event{Branch: u.downstream, Key: "merge", Value: u.upstream,
+				Action: "add"}
 		case setRemote:
 			cmd = newCommand(!quiet, true, "git",
 				"config", "branch."+u.downstream+".remote", u.remote)
-			if !noop {
-				cmd.Stdout = os.Stdout
-				cmd.Stderr = os.Stderr
-				if err = cmd.Run(); err != nil {
-					err = cmdError(cmd, err)
-					return
-				}
+			e = event{Branch: u.downstream, Key: "remote", Value: u.remote,
+				Action: "set"}
+		default:
+			continue
+		}
+		if err = runCommand(cmd); err != nil {
+			return
+		}
+		e.Event = "config"
+		emitEvent(e)
+	}
+	return
+}
+
+func renameBranch(args []string) (err error) {
+	if len(args) != 2 {
+		err = fmt.Errorf("usage: rename <old> <new>")
+		return
+	}
+	var g *graph
+	if g, err = fillGraphForAllBranches(); err != nil {
+		return
+	}
+	var refname, branch string
+	if refname, branch, err = getSymbolicFullNames(args[0]); err != nil {
+		return
+	}
+	n, ok := g.nodes[ref{refname, "."}]
+	if branch == "" || !ok {
+		err = fmt.Errorf("%s is not a local branch", args[0])
+		return
+	}
+	cmd := newCommand(!quiet, true, "git", "branch", "-m", branch, args[1])
+	if err = runCommand(cmd); err != nil {
+		return
+	}
+	return applyUpdates(g.rename(n, refsHeads+args[1], args[1]))
+}
+
+func moveBranch(args []string) (err error) {
+	var onto string
+	fs := flag.NewFlagSet("move", flag.ContinueOnError)
+	fs.StringVar(&onto, "onto", "", "the new upstream branch")
+	if args, err = parseSubcommand(fs, args); err != nil {
+		return
+	}
+	if len(args) != 1 || onto == "" {
+		err = fmt.Errorf("usage: move <branch> -onto <upstream>")
+		return
+	}
+	var g *graph
+	if g, err = fillGraphForAllBranches(); err != nil {
+		return
+	}
+	var refname, branch string
+	if refname, branch, err = getSymbolicFullNames(args[0]); err != nil {
+		return
+	}
+	n, ok := g.nodes[ref{refname, "."}]
+	if branch == "" || !ok {
+		err = fmt.Errorf("%s is not a local branch", args[0])
+		return
+	}
+	if len(n.upstreams) != 1 {
+		err = fmt.Errorf("%s does not have exactly one upstream branch", branch)
+		return
+	}
+	var u *node
+	for u = range n.upstreams {
+	}
+	var ontoname string
+	if ontoname, _, err = getSymbolicFullNames(onto); err != nil {
+		return
+	}
+	downstreams := g.downstreamsOf(n)
+	for _, d := range append(downstreams, n) {
+		if d.name == ontoname {
+			err = fmt.Errorf("%s depends on %s", onto, branch)
+			return
+		}
+	}
+	// the downstreams are replayed from the old tips of the moved branches
+	moved := map[*node]string{n: ""}
+	for _, d := range downstreams {
+		var from *node
+		for m := range d.upstreams {
+			if _, ok := moved[m]; ok && from != nil {
+				err = fmt.Errorf("%s has several upstream branches that are moved",
+					d.branch)
+				return
+			} else if ok {
+				from = m
+			}
+		}
+		moved[d] = ""
+	}
+	for m := range moved {
+		if moved[m], err = revParse(m.branch); err != nil {
+			return
+		}
+	}
+	fullcurrent, current, _ := getSymbolicFullNames("HEAD")
+	updateGrebHeadRef(fullcurrent)
+	_, back, _ := getSymbolicFullNames(change)
+	cmd := newCommand(!quiet, true, "git", "rebase", "--onto", onto, u.branch,
+		branch)
+	if err = runCommand(cmd); err != nil {
+		return
+	}
+	current = branch
+	cmd = newCommand(!quiet, true, "git", "branch", "--set-upstream-to="+onto,
+		branch)
+	if err = runCommand(cmd); err != nil {
+		return
+	}
+	for _, d := range downstreams {
+		for m := range d.upstreams {
+			if _, ok := moved[m]; !ok {
+				continue
+			}
+			cmd = newCommand(!quiet, true, "git", "rebase", "--onto", m.branch,
+				moved[m], d.branch)
+			if err = runCommand(cmd); err != nil {
+				return
 			}
+			current = d.branch
+		}
+	}
+	if back != "" {
+		err = checkoutBranchIfNeeded(back, &current)
+	}
+	return
+}
+
+func splitBranch(args []string) (err error) {
+	var name string
+	fs := flag.NewFlagSet("split", flag.ContinueOnError)
+	fs.StringVar(&name, "b", "", "the name of the new branch")
+	if args, err = parseSubcommand(fs, args); err != nil {
+		return
+	}
+	if len(args) != 2 {
+		err = fmt.Errorf("usage: split <branch> <commit> [-b <name>]")
+		return
+	}
+	var g *graph
+	if g, err = fillGraphForAllBranches(); err != nil {
+		return
+	}
+	var refname, branch string
+	if refname, branch, err = getSymbolicFullNames(args[0]); err != nil {
+		return
+	}
+	n, ok := g.nodes[ref{refname, "."}]
+	if branch == "" || !ok {
+		err = fmt.Errorf("%s is not a local branch", args[0])
+		return
+	}
+	if name == "" {
+		name = branch + "-base"
+	}
+	cmd := newCommand(verbose, false, "git", "merge-base", "--is-ancestor",
+		args[1], branch)
+	if err = cmd.Run(); err != nil {
+		err = fmt.Errorf("%s is not an ancestor of %s", args[1], branch)
+		return
+	}
+	// the tracking configuration is the one of the split
+	cmd = newCommand(!quiet, true, "git", "branch", "--no-track", name, args[1])
+	if err = runCommand(cmd); err != nil {
+		return
+	}
+	_, updates := g.split(n, refsHeads+name, name)
+	return applyUpdates(updates)
+}
+
+func foldBranch(args []string) (err error) {
+	if len(args) != 1 {
+		err = fmt.Errorf("usage: fold <branch>")
+		return
+	}
+	var g *graph
+	if g, err = fillGraphForAllBranches(); err != nil {
+		return
+	}
+	var refname, branch string
+	if refname, branch, err = getSymbolicFullNames(args[0]); err != nil {
+		return
+	}
+	n, ok := g.nodes[ref{refname, "."}]
+	if branch == "" || !ok {
+		err = fmt.Errorf("%s is not a local branch", args[0])
+		return
+	}
+	if len(n.upstreams) == 0 {
+		err = fmt.Errorf("%s does not have upstream branches", branch)
+		return
+	}
+	// its commits would be lost
+	downstreams := g.downstreamsOf(n)
+	if len(downstreams) == 0 {
+		err = fmt.Errorf("%s does not have downstream branches", branch)
+		return
+	}
+	if checkout || skip || local || noop {
+		err = fmt.Errorf("fold pulls every downstream branch, it is " +
+			"incompatible with -c, -s, -l and -n")
+		return
+	}
+	fullcurrent, current, _ := getSymbolicFullNames("HEAD")
+	updateGrebHeadRef(fullcurrent)
+	_, back, _ := getSymbolicFullNames(change)
+	for _, d := range downstreams {
+		if err = pullBranch(d, &current); err != nil {
+			return
+		}
+	}
+	for _, d := range downstreams {
+		if !isAncestor(n.name, d.name) {
+			err = fmt.Errorf("%s does not contain %s, it is not deleted", d.branch,
+				branch)
+			return
 		}
 	}
+	if err = deleteBranch(g, n, &back, &current); err != nil {
+		return
+	}
+	if back != "" {
+		err = checkoutBranchIfNeeded(back, &current)
+	}
 	return
 }
 
@@ -756,20 +1750,52 @@ func checkoutBranchIfNeeded(branch string, current *string) (err error) {
 		arg = branch
 	}
 	cmd := newCommand(!quiet, true, "git", "checkout", arg)
-	if !noop {
-		cmd.Stdout = os.Stdout
-		cmd.Stderr = os.Stderr
-		if err = cmd.Run(); err != nil {
-			err = cmdError(cmd, err)
-			return
-		}
+	if err = runCommand(cmd); err != nil {
+		return
 	}
 	*current = branch
 	return
 }
 
+// it makes ctx done on the first interrupt until restore cancels it and sets
+// the previous context again, the interrupts are ignored until stop
+func notifyInterrupt() (restore, stop func()) {
+	parent := ctx
+	var cancel context.CancelFunc
+	ctx, cancel = context.WithCancel(parent)
+	s := make(chan os.Signal, 1)
+	signal.Notify(s, os.Interrupt)
+	go func() {
+		if _, ok := <-s; ok {
+			cancel()
+		}
+	}()
+	restore = func() {
+		cancel()
+		ctx = parent
+	}
+	stop = func() {
+		signal.Stop(s)
+		close(s)
+	}
+	return
+}
+
+var (
+	// it is done when the run is interrupted, only notifyInterrupt sets it
+	ctx = context.Background()
+	// it creates the commands, the tests replace it
+	commandContext = exec.CommandContext
+)
+
 func newCommand(verbose, color bool, name string, arg ...string) (cmd *exec.Cmd) {
-	cmd = exec.Command(name, arg...)
+	cmd = commandContext(ctx, name, arg...)
+	// the command is interrupted in the same way as with Ctrl-C, it is killed if
+	// it does not finish soon
+	cmd.Cancel = func() error {
+		return cmd.Process.Signal(os.Interrupt)
+	}
+	cmd.WaitDelay = 10 * time.Second
 	if verbose {
 		command, reset := getCommandColor(color)
 		logPrintf("%s%s%s\n", command, cmdArgs(cmd), reset)
@@ -777,6 +1803,29 @@ func newCommand(verbose, color bool, name string, arg ...string) (cmd *exec.Cmd)
 	return
 }
 
+// the standard output of the commands, it is the standard error if the events
+// are written in the standard output
+var commandOutput io.Writer = os.Stdout
+
+// the output of the graphs, the file of export
+var graphOutput io.Writer = os.Stdout
+
+// it runs the command connected to the standard output and error unless noop
+func runCommand(cmd *exec.Cmd) (err error) {
+	if !noop {
+		cmd.Stdout = commandOutput
+		cmd.Stderr = os.Stderr
+		start := time.Now()
+		err = cmd.Run()
+		emitCommandEvent(cmd, time.Since(start))
+		if err != nil {
+			err = cmdError(cmd, err)
+			return
+		}
+	}
+	return
+}
+
 func cmdArgs(cmd *exec.Cmd) string {
 	args := append([]string(nil), cmd.Args...)
 	for i, arg := range args {
@@ -788,7 +1837,7 @@ func cmdArgs(cmd *exec.Cmd) string {
 }
 
 func cmdError(cmd *exec.Cmd, err error) error {
-	return fmt.Errorf("%s: %s", cmdArgs(cmd), err)
+	return fmt.Errorf("%s: %w", cmdArgs(cmd), err)
 }
 
 func logPrintf(format string, v ...interface{}) {
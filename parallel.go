@@ -0,0 +1,204 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"sort"
+	"sync"
+	"time"
+)
+
+// git worktree add and remove are not run at the same time
+var worktreeMutex sync.Mutex
+
+// it pulls the nodes in linked worktrees, up to jobs at the same time, a node
+// is pulled when all its upstreams in nodes have been pulled successfully, it
+// returns the nodes that have not been pulled
+func pullBranchesInParallel(nodes []*node, current *string) (pending []*node,
+	err error) {
+	// the remote-tracking branches are not updated at the same time
+	if err = fetchRemotes(nodes); err != nil {
+		pending = nodes
+		return
+	}
+	index := make(map[*node]int, len(nodes))
+	for i, n := range nodes {
+		index[n] = i
+		// a branch cannot be checked out in two worktrees
+		if n.branch == *current {
+			if err = checkoutBranchIfNeeded("", current); err != nil {
+				pending = nodes
+				return
+			}
+		}
+	}
+	started := make([]bool, len(nodes))
+	done := make([]bool, len(nodes))
+	errs := make([]error, len(nodes))
+	outputs := make([]*bytes.Buffer, len(nodes))
+	ready := func(i int) bool {
+		for u := range nodes[i].upstreams {
+			if j, ok := index[u]; ok && (!done[j] || errs[j] != nil) {
+				return false
+			}
+		}
+		return true
+	}
+	results := make(chan int)
+	running, printed := 0, 0
+	for {
+		for i := range nodes {
+			if running == jobs || err != nil || ctx.Err() != nil {
+				break
+			}
+			if !started[i] && ready(i) {
+				started[i] = true
+				running++
+				go func(i int) {
+					outputs[i], errs[i] = pullInWorktree(nodes[i])
+					results <- i
+				}(i)
+			}
+		}
+		if running == 0 {
+			break
+		}
+		i := <-results
+		running--
+		done[i] = true
+		if errs[i] != nil && err == nil {
+			err = errs[i]
+		}
+		for ; printed < len(nodes) && done[printed]; printed++ {
+			commandOutput.Write(outputs[printed].Bytes())
+		}
+	}
+	for ; printed < len(nodes); printed++ {
+		if done[printed] {
+			commandOutput.Write(outputs[printed].Bytes())
+		}
+	}
+	for i, n := range nodes {
+		if !done[i] || errs[i] != nil {
+			pending = append(pending, n)
+		}
+	}
+	if err == nil && ctx.Err() != nil {
+		err = errInterrupted
+	}
+	return
+}
+
+// it fetches once every remote of the upstreams of the nodes that are pulled
+func fetchRemotes(nodes []*node) (err error) {
+	fetched := make(map[string]struct{})
+	for _, n := range nodes {
+		if local && hasRemoteUpstreams(n) {
+			continue
+		}
+		var remotes nodesort
+		for u := range n.upstreams {
+			remotes = append(remotes, u)
+		}
+		sort.Sort(&remotes)
+		for _, u := range remotes {
+			if _, ok := fetched[u.remote]; ok || u.remote == "." {
+				continue
+			}
+			fetched[u.remote] = struct{}{}
+			if ctx.Err() != nil {
+				err = errInterrupted
+				return
+			}
+			cmd := newCommand(!quiet, true, "git", "fetch", u.remote)
+			if err = runCommand(cmd); err != nil {
+				return
+			}
+		}
+	}
+	return
+}
+
+// the arguments of git to merge or rebase the branch once its remotes are
+// fetched, as git pull does without fetching
+func mergeArgs(n *node) (args []string) {
+	if isRebase(n) {
+		// the upstream and --fork-point are taken from the configuration
+		return []string{"rebase"}
+	}
+	return []string{"merge", "--no-edit"}
+}
+
+// it pulls the node in a new linked worktree, the worktree is kept if the pull
+// fails unless atomic
+func pullInWorktree(n *node) (output *bytes.Buffer, err error) {
+	output = new(bytes.Buffer)
+	if local && hasRemoteUpstreams(n) {
+		recordResult(result{branch: n.branch, action: "skipped by -l"})
+		return
+	}
+	var dir string
+	if dir, err = os.MkdirTemp("", "greb-"); err != nil {
+		return
+	}
+	keep := false
+	defer func() {
+		if !keep {
+			os.RemoveAll(dir)
+		}
+	}()
+	worktreeMutex.Lock()
+	err = runBuffered(output, "", "git", "worktree", "add", dir, n.branch)
+	worktreeMutex.Unlock()
+	if err != nil {
+		return
+	}
+	emitEvent(event{Event: "branch", Branch: n.branch})
+	old, _ := revParse(n.branch)
+	if err = runBuffered(output, dir, "git", mergeArgs(n)...); err != nil {
+		recordResult(result{branch: n.branch, action: "failed", old: old})
+		if !atomic {
+			keep = true
+			fmt.Fprintf(output, "greb: the worktree of %s is kept in %s\n",
+				n.branch, dir)
+			return
+		}
+		// the branch cannot be reset while it is being rebased or merged
+		runBuffered(output, dir, "git", mergeArgs(n)[0], "--abort")
+	} else {
+		hash, _ := revParse(n.branch)
+		emitEvent(event{Event: "updated", Branch: n.branch, Old: old, New: hash})
+		recordPull(n.branch, old, hash)
+	}
+	worktreeMutex.Lock()
+	rerr := runBuffered(output, "", "git", "worktree", "remove", "--force", dir)
+	worktreeMutex.Unlock()
+	if err == nil {
+		err = rerr
+	}
+	return
+}
+
+// it runs the command in the directory writing everything into the buffer
+func runBuffered(output *bytes.Buffer, dir, name string, arg ...string) (
+	err error) {
+	cmd := newCommand(false, false, name, arg...)
+	cmd.Dir = dir
+	if !quiet {
+		command, reset := getCommandColor(true)
+		fmt.Fprintf(output, "greb: %s%s%s\n", command, cmdArgs(cmd), reset)
+	}
+	if !noop {
+		cmd.Stdout = output
+		cmd.Stderr = output
+		start := time.Now()
+		err = cmd.Run()
+		emitCommandEvent(cmd, time.Since(start))
+		if err != nil {
+			err = cmdError(cmd, err)
+			return
+		}
+	}
+	return
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// it runs greb with jobs and counts the fetches
+func testParallelGreb(t *testing.T, fetches *int) error {
+	t.Helper()
+	oldcommand, oldjobs := commandContext, jobs
+	t.Cleanup(func() {
+		commandContext, jobs = oldcommand, oldjobs
+	})
+	commandContext = func(ctx context.Context, name string,
+		arg ...string) *exec.Cmd {
+		if len(arg) > 0 && arg[0] == "fetch" {
+			*fetches++
+		}
+		return exec.CommandContext(ctx, name, arg...)
+	}
+	jobs = 2
+	return greb(nil)
+}
+
+func TestPullBranchesInParallel(t *testing.T) {
+	newTestRepository(t)
+	origin := filepath.Join(t.TempDir(), "origin")
+	testGit(t, "init", "-q", "--bare", origin)
+	testGit(t, "remote", "add", "origin", origin)
+	testGit(t, "push", "-q", "-u", "origin", "master")
+	testGit(t, "config", "pull.rebase", "true")
+	testGit(t, "checkout", "-q", "-b", "a", "-t", "master")
+	testCommit(t, "a")
+	testGit(t, "checkout", "-q", "-b", "b", "-t", "a")
+	testCommit(t, "b")
+	testGit(t, "checkout", "-q", "-b", "c", "-t", "origin/master")
+	testCommit(t, "c")
+	testGit(t, "checkout", "-q", "master")
+	clone := filepath.Join(t.TempDir(), "clone")
+	testGit(t, "clone", "-q", origin, clone)
+	testGit(t, "-C", clone, "commit", "-q", "--allow-empty", "-m", "new")
+	testGit(t, "-C", clone, "push", "-q")
+	var fetches int
+	if err := testParallelGreb(t, &fetches); err != nil {
+		t.Fatal(err)
+	}
+	// origin is fetched once for master and c
+	if fetches != 1 {
+		t.Error(fetches)
+	}
+	for _, b := range []string{"master", "a", "b", "c"} {
+		testGit(t, "merge-base", "--is-ancestor", "origin/master", b)
+	}
+	testGit(t, "merge-base", "--is-ancestor", "a", "b")
+	if b := testGit(t, "branch", "--show-current"); b != "master" {
+		t.Error(b)
+	}
+	if w := testGit(t, "worktree", "list", "--porcelain"); strings.Count(w,
+		"worktree ") != 1 {
+		t.Error(w)
+	}
+}
+
+func TestPullBranchesInParallelFailure(t *testing.T) {
+	newTestRepository(t)
+	testGit(t, "checkout", "-q", "-b", "a", "-t", "master")
+	testCommit(t, "file")
+	testGit(t, "checkout", "-q", "-b", "b", "-t", "a")
+	testCommit(t, "b")
+	testGit(t, "checkout", "-q", "-b", "c", "-t", "master")
+	testCommit(t, "c")
+	testGit(t, "checkout", "-q", "master")
+	testCommit(t, "file.tmp")
+	testGit(t, "mv", "file.tmp", "file")
+	testGit(t, "commit", "-q", "--amend", "--no-edit")
+	a, b := testGit(t, "rev-parse", "a"), testGit(t, "rev-parse", "b")
+	var fetches int
+	err := testParallelGreb(t, &fetches)
+	// the worktree of a is kept
+	for _, line := range strings.Split(testGit(t, "worktree", "list",
+		"--porcelain"), "\n") {
+		if dir, ok := strings.CutPrefix(line, "worktree "); ok &&
+			strings.Contains(dir, "greb-") {
+			t.Cleanup(func() {
+				os.RemoveAll(dir)
+			})
+		}
+	}
+	if err == nil {
+		t.Fatal(err)
+	}
+	if fetches != 0 {
+		t.Error(fetches)
+	}
+	// the downstream of the failure is not pulled, the other branches are
+	if h := testGit(t, "rev-parse", "b"); h != b {
+		t.Error(h)
+	}
+	if h := testGit(t, "rev-parse", "a"); h != a {
+		t.Error(h)
+	}
+	testGit(t, "merge-base", "--is-ancestor", "master", "c")
+}
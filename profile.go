@@ -0,0 +1,208 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"path"
+	"strings"
+)
+
+// the options that select how the branches are pulled, a profile selects one
+// with greb.profile.<name>.mode
+var modeOptions = []string{"rebase", "merge", "interactive", "checkout", "skip"}
+
+// the options that are incompatible with each other, the one of git config is
+// not used if the command line gives any
+var exclusiveOptions = []string{"bash", "zsh", "fish", "git-completion", "t",
+	"dot", "x", "mermaid", "plantuml", "log", "render", "r", "m", "i", "c", "s",
+	"prune-gone"}
+
+// an option of greb in git config
+type configOption struct {
+	key   string
+	name  string
+	value string
+}
+
+// the patterns of the branches given with -exclude, it may be given many times
+// or with a list separated by commas
+type patterns []string
+
+func (p *patterns) String() string {
+	return strings.Join(*p, ",")
+}
+
+func (p *patterns) Set(value string) error {
+	for _, pattern := range strings.Split(value, ",") {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("invalid pattern: %s", pattern)
+		}
+		*p = append(*p, pattern)
+	}
+	return nil
+}
+
+// whether the short name of the branch matches any of the patterns
+func (p *patterns) match(refname string) bool {
+	for _, pattern := range *p {
+		if ok, _ := path.Match(pattern, strings.TrimPrefix(refname,
+			refsHeads)); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// it sets the options of the flag set that are not given in the command line
+// with the ones of greb.<option> and then the ones of the profile in git
+// config, the profile is the one given with -profile or greb.profile
+func updateFlagsWithOptions(fs *flag.FlagSet) (err error) {
+	var options []configOption
+	if options, err = readConfigOptions(); err != nil {
+		return
+	}
+	var global, selected []configOption
+	name := profile
+	for _, o := range options {
+		if o.key == "greb.profile" {
+			// -profile overrides it
+			if profile == "" {
+				name = o.value
+			}
+		} else if !strings.HasPrefix(o.key, "greb.profile.") {
+			global = append(global, o)
+		}
+	}
+	if name != "" {
+		prefix := "greb.profile." + name + "."
+		for _, o := range options {
+			if strings.HasPrefix(o.key, prefix) {
+				selected = append(selected, o)
+			}
+		}
+		if len(selected) == 0 {
+			err = fmt.Errorf("unknown profile: %s", name)
+			return
+		}
+	}
+	// the options before a subcommand are given too
+	given := make(map[flag.Value]struct{})
+	visit := func(f *flag.Flag) {
+		given[f.Value] = struct{}{}
+	}
+	flag.Visit(visit)
+	fs.Visit(visit)
+	for _, o := range append(global, selected...) {
+		if err = setConfigOption(fs, o, given); err != nil {
+			return
+		}
+	}
+	return
+}
+
+// the options of greb in the git config files in order, the names of the
+// options are the last part of the keys
+func readConfigOptions() (options []configOption, err error) {
+	cmd := newCommand(verbose, false, "git", "config", "-z", "--get-regexp",
+		`^greb\.`)
+	output, e := cmd.Output()
+	if e != nil {
+		// there is no option
+		if verbose {
+			logPrintf("-> no config\n")
+		}
+		return
+	}
+	for _, entry := range strings.Split(string(output), "\x00") {
+		if entry == "" {
+			continue
+		}
+		// a bool option may not have value
+		key, value, _ := strings.Cut(entry, "\n")
+		options = append(options, configOption{key, key[strings.LastIndex(key,
+			".")+1:], value})
+	}
+	return
+}
+
+// it sets the option unless it has been given in the command line or it is
+// not in the flag set, an option that is incompatible with others unsets them
+// unless any has been given
+func setConfigOption(fs *flag.FlagSet, o configOption,
+	given map[flag.Value]struct{}) (err error) {
+	name, value := o.name, o.value
+	if name == "mode" {
+		name, value = value, "true"
+		if !isOneOf(name, modeOptions) {
+			err = fmt.Errorf("%s: invalid mode: %s", o.key, o.value)
+			return
+		}
+	}
+	// git lowercases the keys, greb.C would be greb.c
+	if len(name) == 1 {
+		logPrintf("%s: the options are given by their long names\n", o.key)
+		return
+	}
+	// other programs may add their own options
+	if flag.Lookup(name) == nil || name == "profile" {
+		logPrintf("%s: unknown option\n", o.key)
+		return
+	}
+	// an option that the subcommand does not have
+	f := fs.Lookup(name)
+	if f == nil {
+		return
+	}
+	if isBoolFlag(f) {
+		if value, err = configBool(o.key, value); err != nil {
+			return
+		}
+	}
+	if _, ok := given[f.Value]; ok {
+		return
+	}
+	if isOneOf(name, exclusiveOptions) {
+		for _, e := range exclusiveOptions {
+			if _, ok := given[flag.Lookup(e).Value]; ok {
+				return
+			}
+		}
+		if value != "false" && value != "" {
+			for _, e := range exclusiveOptions {
+				e := flag.Lookup(e)
+				e.Value.Set(e.DefValue)
+			}
+		}
+	}
+	if verbose {
+		logPrintf("-> %s=%s\n", o.key, o.value)
+	}
+	if err = f.Value.Set(value); err != nil {
+		err = fmt.Errorf("%s: %w", o.key, err)
+	}
+	return
+}
+
+// whether the option is any of the options or their aliases
+func isOneOf(name string, options []string) bool {
+	f := flag.Lookup(name)
+	for _, o := range options {
+		if f != nil && f.Value == flag.Lookup(o).Value {
+			return true
+		}
+	}
+	return false
+}
+
+// the value of a bool option of git config as true or false
+func configBool(key, value string) (b string, err error) {
+	switch strings.ToLower(value) {
+	case "", "true", "yes", "on", "1":
+		b = "true"
+	case "false", "no", "off", "0":
+		b = "false"
+	default:
+		err = fmt.Errorf("%s: invalid bool: %s", key, value)
+	}
+	return
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"flag"
+	"testing"
+)
+
+func TestPatterns(t *testing.T) {
+	var p patterns
+	if err := p.Set("wip/*,*-old"); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.Set("["); err == nil {
+		t.Error(p)
+	}
+	for refname, match := range map[string]bool{
+		"refs/heads/wip/foo":     true,
+		"refs/heads/wip/foo/bar": false,
+		"refs/heads/foo-old":     true,
+		"refs/heads/foo":         false,
+	} {
+		if p.match(refname) != match {
+			t.Error(refname, match)
+		}
+	}
+	if s := p.String(); s != "wip/*,*-old" {
+		t.Error(s)
+	}
+}
+
+func TestConfigBool(t *testing.T) {
+	for value, b := range map[string]string{"": "true", "Yes": "true",
+		"on": "true", "1": "true", "false": "false", "off": "false"} {
+		if v, err := configBool("greb.local", value); err != nil || v != b {
+			t.Error(value, v, err)
+		}
+	}
+	if _, err := configBool("greb.local", "maybe"); err == nil {
+		t.Error(err)
+	}
+}
+
+func TestSetConfigOption(t *testing.T) {
+	r, m, j := rebase, merge, jobs
+	defer func() {
+		rebase, merge, jobs = r, m, j
+	}()
+	rebase, merge, jobs = false, false, 1
+	given := map[flag.Value]struct{}{}
+	for _, o := range []configOption{
+		{"greb.rebase", "rebase", ""},
+		{"greb.profile.p.mode", "mode", "merge"},
+		{"greb.profile.p.jobs", "jobs", "4"},
+	} {
+		if err := setConfigOption(flag.CommandLine, o, given); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if rebase || !merge || jobs != 4 {
+		t.Error(rebase, merge, jobs)
+	}
+	given[flag.Lookup("r").Value] = struct{}{}
+	rebase, merge = true, false
+	if err := setConfigOption(flag.CommandLine, configOption{"greb.mode", "mode",
+		"merge"},
+		given); err != nil || !rebase || merge {
+		t.Error(err, rebase, merge)
+	}
+	// they are ignored
+	c := checkout
+	defer func() {
+		checkout = c
+	}()
+	checkout = false
+	for _, o := range []configOption{
+		{"greb.foo", "foo", "true"},
+		{"greb.c", "c", "true"},
+		{"greb.profile", "profile", "p"},
+	} {
+		if err := setConfigOption(flag.CommandLine, o, given); err != nil ||
+			checkout {
+			t.Error(o, err)
+		}
+	}
+	for _, o := range []configOption{
+		{"greb.mode", "mode", "push"},
+		{"greb.jobs", "jobs", "x"},
+	} {
+		if err := setConfigOption(flag.CommandLine, o, given); err == nil {
+			t.Error(o)
+		}
+	}
+}
+
+func TestUpdateFlagsWithOptions(t *testing.T) {
+	newTestRepository(t)
+	r, m, c, p := rebase, merge, checkout, profile
+	defer func() {
+		rebase, merge, checkout, profile = r, m, c, p
+	}()
+	rebase, merge, checkout = false, false, false
+	testGit(t, "config", "greb.profile", "morning")
+	testGit(t, "config", "greb.profile.morning.mode", "rebase")
+	testGit(t, "config", "greb.profile.other.mode", "merge")
+	testGit(t, "config", "greb.unrelated", "true")
+	testGit(t, "config", "greb.C", "master")
+	profile = "other"
+	if err := updateFlagsWithOptions(flag.CommandLine); err != nil {
+		t.Fatal(err)
+	}
+	if rebase || !merge || checkout {
+		t.Error(rebase, merge, checkout)
+	}
+	merge, profile = false, ""
+	if err := updateFlagsWithOptions(flag.CommandLine); err != nil {
+		t.Fatal(err)
+	}
+	if !rebase || merge || checkout {
+		t.Error(rebase, merge, checkout)
+	}
+}
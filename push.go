@@ -0,0 +1,180 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// local nodes, upstreams first
+func pushOrder(g *graph) (nodes []*node) {
+	var roots nodesort
+	for _, n := range g.nodes {
+		if n.remote == "." && len(n.upstreams) == 0 {
+			roots = append(roots, n)
+		}
+	}
+	sort.Sort(&roots)
+	nodes = append(nodes, roots...)
+	for _, n := range g.sort() {
+		if n.remote == "." {
+			nodes = append(nodes, n)
+		}
+	}
+	return
+}
+
+// the push remote, the ref pushed to in it and the value of its
+// remote-tracking branch, if any
+type pushTarget struct {
+	remote string
+	name   string
+	hash   string
+}
+
+// push targets of the local branches that have a push remote, by branch
+func getPushTargets(g *graph) (targets map[string]pushTarget) {
+	targets = make(map[string]pushTarget)
+	for _, n := range pushOrder(g) {
+		remote, err := getPushRemote(n.branch)
+		if err != nil {
+			continue
+		}
+		// the push ref of git, it may not have the same name as the branch
+		t := pushTarget{remote, n.name, ""}
+		if push, err := gitOutput("for-each-ref", "--format=%(push)",
+			n.name); err == nil && push != "" {
+			if r, err := findRemoteRef(push); err == nil && r.remote == remote {
+				t.name = r.name
+			}
+			t.hash, _ = revParse(push)
+		} else if refname, err := findRemoteTrackingBranch(ref{n.name,
+			remote}); err == nil {
+			t.hash, _ = revParse(refname)
+		}
+		targets[n.branch] = t
+	}
+	return
+}
+
+func getPushRemote(branch string) (remote string, err error) {
+	for _, option := range []string{"branch." + branch + ".pushRemote",
+		"remote.pushDefault"} {
+		cmd := newCommand(verbose, false, "git", "config", option)
+		var output []byte
+		if output, err = cmd.CombinedOutput(); err == nil {
+			remote = strings.TrimSpace(string(output))
+			if verbose {
+				logPrintf("-> %s\n", remote)
+			}
+			return
+		}
+		if verbose {
+			logPrintf("-> no config\n")
+		}
+	}
+	err = fmt.Errorf("branch %s does not have a push remote", branch)
+	return
+}
+
+// it adds the push destinations of the local branches to the graph, following
+// the push remote, branch.<name>.remote and push.default
+func fillPushTargets(g *graph) {
+	mode := "simple"
+	cmd := newCommand(verbose, false, "git", "config", "push.default")
+	if output, err := cmd.CombinedOutput(); err == nil {
+		mode = strings.TrimSpace(string(output))
+	}
+	if verbose {
+		logPrintf("-> %s\n", mode)
+	}
+	if mode == "nothing" {
+		return
+	}
+	for _, n := range pushOrder(g) {
+		remote, rr, _ := getTrackingInfo(n.branch)
+		name := n.name
+		if r, err := getPushRemote(n.branch); err == nil {
+			if r != remote {
+				rr = nil
+			}
+			remote = r
+		}
+		if remote == "" || remote == "." {
+			continue
+		}
+		if mode == "upstream" || mode == "tracking" {
+			if len(rr) != 1 {
+				continue
+			}
+			name = rr[0]
+		}
+		p, ok := g.node(ref{name, remote})
+		if !ok {
+			rn, err := findRemoteTrackingBranch(p.ref)
+			if err != nil || !strings.HasPrefix(rn, refsRemotes) {
+				delete(g.nodes, p.ref)
+				continue
+			}
+			p.branch = rn[len(refsRemotes):]
+		}
+		ahead, behind, err := getAheadBehind(n.branch, refsRemotes+p.branch)
+		if err != nil {
+			continue
+		}
+		g.pushEdge(n, p, ahead, behind)
+	}
+}
+
+func pushBranches(g *graph, targets map[string]pushTarget) (err error) {
+	var failed []string
+	var outcomes []string
+	for _, n := range pushOrder(g) {
+		t, ok := targets[n.branch]
+		if !ok {
+			continue
+		}
+		var outcome string
+		if outcome, err = pushBranch(n.branch, t); err != nil {
+			failed = append(failed, n.branch)
+			outcome = fmt.Sprintf("failed: %s", err)
+			err = nil
+		}
+		outcomes = append(outcomes, fmt.Sprintf("%s -> %s: %s", n.branch, t.remote,
+			outcome))
+	}
+	for _, o := range outcomes {
+		logPrintf("%s\n", o)
+	}
+	if len(failed) > 0 {
+		err = fmt.Errorf("failed to push: %s", strings.Join(failed, ", "))
+	}
+	return
+}
+
+func pushBranch(branch string, t pushTarget) (outcome string, err error) {
+	var hash string
+	if hash, err = revParse(branch); err != nil {
+		return
+	}
+	if hash == t.hash {
+		outcome = "up to date"
+		return
+	}
+	args := []string{"push"}
+	outcome = "pushed"
+	if t.hash != "" {
+		cmd := newCommand(verbose, false, "git", "merge-base", "--is-ancestor",
+			t.hash, hash)
+		if cmd.Run() != nil {
+			args = append(args, "--force-with-lease="+t.name+":"+t.hash)
+			outcome = "force pushed"
+		}
+	}
+	args = append(args, t.remote, branch+":"+t.name)
+	cmd := newCommand(!quiet, true, "git", args...)
+	if err = runCommand(cmd); err == nil && noop {
+		outcome = "would be " + outcome
+	}
+	return
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestPushBranches(t *testing.T) {
+	newTestRepository(t)
+	origin := filepath.Join(t.TempDir(), "origin")
+	testGit(t, "init", "-q", "--bare", origin)
+	testGit(t, "remote", "add", "origin", origin)
+	testGit(t, "config", "push.default", "upstream")
+	testGit(t, "checkout", "-q", "-b", "feature")
+	testCommit(t, "feature")
+	// the remote branch has other name
+	testGit(t, "push", "-q", "-u", "origin", "feature:other")
+	testGit(t, "config", "branch.feature.pushRemote", "origin")
+	testGit(t, "commit", "-q", "--amend", "-m", "amended")
+	g, err := fillGraphForAllBranches()
+	if err != nil {
+		t.Fatal(err)
+	}
+	targets := getPushTargets(g)
+	if f := targets["feature"]; f.remote != "origin" ||
+		f.name != "refs/heads/other" || f.hash == "" {
+		t.Fatal(f)
+	}
+	defer func() {
+		noop = false
+	}()
+	noop = true
+	if o, err := pushBranch("feature", targets["feature"]); err != nil ||
+		o != "would be force pushed" {
+		t.Error(o, err)
+	}
+	noop = false
+	if err := pushBranches(g, targets); err != nil {
+		t.Fatal(err)
+	}
+	if h := testGit(t, "--git-dir", origin, "rev-parse", "other"); h !=
+		testGit(t, "rev-parse", "feature") {
+		t.Error(h)
+	}
+	if b := testGit(t, "--git-dir", origin, "branch", "--list",
+		"feature"); b != "" {
+		t.Error(b)
+	}
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"html"
+	"os"
+	"os/exec"
+	"sort"
+	"strings"
+)
+
+const renderHTML = `<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>%v</title>
+<style>
+body { font-family: sans-serif; }
+</style>
+</head>
+<body>
+<h1>%[1]v</h1>
+%v</body>
+</html>
+`
+
+// it writes the graph in the file with the given format: svg, png or html
+func renderGraph(g *graph, current, format, file string) (err error) {
+	var tooltips map[*node]string
+	switch format {
+	case "svg", "png":
+	case "html":
+		if tooltips, err = graphTooltips(g); err != nil {
+			return
+		}
+	default:
+		err = fmt.Errorf("invalid render format: %v", format)
+		return
+	}
+	var output []byte
+	if format == "png" {
+		if output, err = renderDot(g, current, "png", tooltips); err != nil {
+			return
+		}
+	} else {
+		var svg string
+		if svg, err = renderSVG(g, current, tooltips); err != nil {
+			return
+		}
+		if format == "html" {
+			// the xml prolog and doctype are not allowed inside html
+			if i := strings.Index(svg, "<svg"); i > 0 {
+				svg = svg[i:]
+			}
+			svg = fmt.Sprintf(renderHTML, html.EscapeString(current), svg)
+		}
+		output = []byte(svg)
+	}
+	if verbose {
+		logPrintf("-> %v\n", file)
+	}
+	if noop {
+		return
+	}
+	err = os.WriteFile(file, output, 0666)
+	return
+}
+
+// it uses graphviz if it is installed and the layout of graph.svg otherwise
+func renderSVG(g *graph, current string,
+	tooltips map[*node]string) (svg string, err error) {
+	if _, e := exec.LookPath("dot"); e != nil {
+		if verbose {
+			logPrintf("-> no graphviz\n")
+		}
+		svg = g.svg(current, currentColorName, remoteColorName, tooltips)
+		return
+	}
+	var output []byte
+	if output, err = renderDot(g, current, "svg", tooltips); err != nil {
+		return
+	}
+	svg = string(output)
+	return
+}
+
+func renderDot(g *graph, current, format string,
+	tooltips map[*node]string) (output []byte, err error) {
+	cmd := newCommand(verbose, false, "dot", "-T"+format)
+	cmd.Stdin = bytes.NewBufferString(g.dotTooltips(current, currentColorName,
+		remoteColorName, tooltips))
+	if output, err = cmd.Output(); err != nil {
+		err = cmdError(cmd, err)
+	}
+	return
+}
+
+// the reasons, the commits ahead and behind every upstream and the subject of
+// the last commit of every node
+func graphTooltips(g *graph) (tooltips map[*node]string, err error) {
+	tooltips = make(map[*node]string, len(g.nodes))
+	for _, n := range g.nodes {
+		var lines []string
+		var upstreams nodesort
+		for u := range n.upstreams {
+			upstreams = append(upstreams, u)
+		}
+		sort.Sort(&upstreams)
+		for _, u := range upstreams {
+			var ahead, behind int
+			ahead, behind, err = getAheadBehind(n.branch, u.branch)
+			if err != nil {
+				return
+			}
+			lines = append(lines, fmt.Sprintf("%v (%v): ahead %v, behind %v",
+				u.branch, n.upstreams[u], ahead, behind))
+		}
+		for _, merge := range n.gone {
+			lines = append(lines, fmt.Sprintf("%v: gone", merge))
+		}
+		var subject string
+		if subject, err = gitOutput("log", "-1", "--format=%s", n.branch,
+			"--"); err != nil {
+			return
+		}
+		lines = append(lines, subject)
+		tooltips[n] = strings.Join(lines, "\n")
+	}
+	return
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+)
+
+const resumeFile = "GREB_RESUME"
+
+func resumePath() (path string, err error) {
+	return gitOutput("rev-parse", "--git-path", resumeFile)
+}
+
+// it records the branches that have not been pulled
+func writeResume(nodes []*node) (err error) {
+	var branches []string
+	for _, n := range nodes {
+		branches = append(branches, n.branch)
+	}
+	logPrintf("interrupted, pending branches: %s\n", strings.Join(branches, ", "))
+	if noop {
+		return
+	}
+	var path string
+	if path, err = resumePath(); err != nil {
+		return
+	}
+	content := strings.Join(branches, "\n") + "\n"
+	if err = os.WriteFile(path, []byte(content), 0666); err != nil {
+		logPrintf("%s\n", err)
+	}
+	return
+}
+
+// the branches that were recorded by writeResume
+func readResume() (branches []string, err error) {
+	var path string
+	if path, err = resumePath(); err != nil {
+		return
+	}
+	var f *os.File
+	if f, err = os.Open(path); err != nil {
+		if os.IsNotExist(err) {
+			err = fmt.Errorf("there is nothing to resume")
+		}
+		return
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		if b := strings.TrimSpace(scanner.Text()); b != "" {
+			branches = append(branches, b)
+		}
+	}
+	if err = scanner.Err(); err == nil && len(branches) == 0 {
+		err = fmt.Errorf("there is nothing to resume")
+	}
+	if verbose {
+		logPrintf("-> %s\n", strings.Join(branches, ", "))
+	}
+	return
+}
+
+func removeResume() (err error) {
+	if noop {
+		return
+	}
+	var path string
+	if path, err = resumePath(); err != nil {
+		return
+	}
+	if err = os.Remove(path); os.IsNotExist(err) {
+		err = nil
+	}
+	return
+}
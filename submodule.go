@@ -0,0 +1,198 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// an initialized submodule of the repository
+type submodule struct {
+	name string
+	// relative to the top level directory
+	path string
+}
+
+// it runs greb with the same options in every initialized submodule, they
+// recurse into their own submodules, and it commits the submodules that follow
+// a branch in the branches that follow it too if commitSubmodules, the branches
+// are the ones of the superproject
+func updateSubmodules(branches []string) (err error) {
+	var top string
+	if top, err = gitOutput("rev-parse", "--show-toplevel"); err != nil {
+		return
+	}
+	var submodules []submodule
+	if submodules, err = getSubmodules(top); err != nil {
+		return
+	}
+	var exe string
+	if exe, err = os.Executable(); err != nil {
+		return
+	}
+	var file string
+	if render != "" {
+		if file, err = filepath.Abs(branches[0]); err != nil {
+			return
+		}
+	}
+	for _, s := range submodules {
+		dir := filepath.Join(top, s.path)
+		if !quiet {
+			logPrintf("entering %s\n", s.path)
+		}
+		// the branch to return to and the branches are the ones of the
+		// superproject, the graph of the submodule is rendered in its own file
+		var args []string
+		if file != "" {
+			args = childArgs([]string{submoduleFile(file, s)}, "C", "events")
+		} else {
+			args = childArgs(nil, "C", "events")
+		}
+		cmd := newCommand(verbose, false, exe, args...)
+		cmd.Dir = dir
+		cmd.Stdin = os.Stdin
+		cmd.Stdout = commandOutput
+		cmd.Stderr = os.Stderr
+		if err = cmd.Run(); err != nil {
+			err = fmt.Errorf("submodule %s: %w", s.path, err)
+			return
+		}
+		if commitSubmodules {
+			if err = commitSubmodule(top, s); err != nil {
+				return
+			}
+		}
+	}
+	return
+}
+
+// the file of the superproject with the path of the submodule before the
+// extension, i.e. graph-lib-foo.svg for graph.svg and lib/foo
+func submoduleFile(file string, s submodule) string {
+	ext := filepath.Ext(file)
+	return strings.TrimSuffix(file, ext) + "-" + strings.ReplaceAll(
+		filepath.ToSlash(s.path), "/", "-") + ext
+}
+
+// the submodules checked out in the top level directory
+func getSubmodules(top string) (submodules []submodule, err error) {
+	cmd := newCommand(verbose, false, "git", "submodule", "foreach", "--quiet",
+		`printf '%s\0%s\0' "$name" "$sm_path"`)
+	cmd.Dir = top
+	var output []byte
+	if output, err = cmd.Output(); err != nil {
+		err = cmdError(cmd, err)
+		return
+	}
+	fields := strings.Split(strings.TrimSuffix(string(output), "\x00"), "\x00")
+	for i := 0; i+1 < len(fields); i += 2 {
+		submodules = append(submodules, submodule{fields[i], fields[i+1]})
+	}
+	if verbose {
+		var paths []string
+		for _, s := range submodules {
+			paths = append(paths, s.path)
+		}
+		logPrintf("-> %s\n", strings.Join(paths, ", "))
+	}
+	return
+}
+
+// it commits the submodule in every local branch whose .gitmodules gives the
+// branch checked out in the submodule as submodule.<name>.branch if it has
+// moved, the other branches are checked out to commit and the current one is
+// checked out again
+func commitSubmodule(top string, s submodule) (err error) {
+	dir := filepath.Join(top, s.path)
+	b, e := gitOutput("-C", dir, "symbolic-ref", "-q", "--short", "HEAD")
+	if e != nil {
+		return
+	}
+	var head, output string
+	if head, err = gitOutput("-C", dir, "rev-parse", "HEAD"); err != nil {
+		return
+	}
+	var current string
+	if _, current, err = getSymbolicFullNames("HEAD"); err != nil {
+		return
+	}
+	if output, err = gitOutput("-C", top, "for-each-ref",
+		"--format=%(refname:short)", refsHeads); err != nil {
+		return
+	}
+	var branches []string
+	for _, branch := range strings.Fields(output) {
+		cmd := newCommand(verbose, false, "git", "config", "--blob",
+			branch+":.gitmodules", "submodule."+s.name+".branch")
+		cmd.Dir = top
+		output, e := cmd.Output()
+		if e != nil {
+			if verbose {
+				logPrintf("-> no config\n")
+			}
+			continue
+		}
+		name := strings.TrimSpace(string(output))
+		if verbose {
+			logPrintf("-> %s\n", name)
+		}
+		// the same name as the branch of the superproject
+		if name == "." {
+			name = branch
+		}
+		if name != b {
+			continue
+		}
+		// a submodule that has just been added is only in the index of the
+		// current branch
+		gitlink, _ := gitOutput("-C", top, "rev-parse", "-q", "--verify",
+			branch+":"+s.path)
+		if gitlink != head && (gitlink != "" || branch == current) {
+			branches = append(branches, branch)
+		}
+	}
+	if len(branches) == 0 {
+		return
+	}
+	// the original HEAD, it may be detached
+	original := []string{current}
+	if current == "" {
+		var hash string
+		if hash, err = gitOutput("-C", top, "rev-parse", "HEAD"); err != nil {
+			return
+		}
+		original = []string{"--detach", hash}
+	}
+	checkout := func(arg ...string) error {
+		cmd := newCommand(!quiet, true, "git", append([]string{"checkout"},
+			arg...)...)
+		cmd.Dir = top
+		return runCommand(cmd)
+	}
+	checkedOut := false
+	defer func() {
+		if checkedOut {
+			if cerr := checkout(original...); err == nil {
+				err = cerr
+			}
+		}
+	}()
+	for _, branch := range branches {
+		if branch != current {
+			checkedOut = true
+			if err = checkout(branch); err != nil {
+				return
+			}
+			current = branch
+		}
+		cmd := newCommand(!quiet, true, "git", "commit", "-m",
+			fmt.Sprintf("Update %s to %s", s.path, b), "--", s.path)
+		cmd.Dir = top
+		if err = runCommand(cmd); err != nil {
+			return
+		}
+	}
+	return
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestSubmoduleFile(t *testing.T) {
+	for _, c := range [][3]string{
+		{"/tmp/graph.svg", "lib", "/tmp/graph-lib.svg"},
+		{"/tmp/graph.svg", "lib/foo", "/tmp/graph-lib-foo.svg"},
+		{"/tmp/graph-lib.html", "bar", "/tmp/graph-lib-bar.html"},
+		{"/tmp/graph", "lib", "/tmp/graph-lib"},
+	} {
+		if f := submoduleFile(c[0], submodule{"name", c[1]}); f != c[2] {
+			t.Error(c, f)
+		}
+	}
+}
+
+func TestCommitSubmodule(t *testing.T) {
+	newTestRepository(t)
+	lib := filepath.Join(t.TempDir(), "lib")
+	testGit(t, "init", "-q", "-b", "master", lib)
+	testGit(t, "-C", lib, "commit", "-q", "--allow-empty", "-m", "lib")
+	testGit(t, "-c", "protocol.file.allow=always", "submodule", "add", "-q",
+		"-b", "master", lib, "lib")
+	testGit(t, "commit", "-q", "-m", "lib")
+	// other follows master of lib too, unrelated does not have lib
+	testGit(t, "branch", "other")
+	testGit(t, "checkout", "-q", "-b", "unrelated", "master~1")
+	testGit(t, "checkout", "-q", "master")
+	unrelated := testGit(t, "rev-parse", "unrelated")
+	testGit(t, "-C", "lib", "commit", "-q", "--allow-empty", "-m", "new")
+	head := testGit(t, "-C", "lib", "rev-parse", "HEAD")
+	top := testGit(t, "rev-parse", "--show-toplevel")
+	if err := commitSubmodule(top, submodule{"lib", "lib"}); err != nil {
+		t.Fatal(err)
+	}
+	for _, b := range []string{"master", "other"} {
+		if h := testGit(t, "rev-parse", b+":lib"); h != head {
+			t.Error(b, h)
+		}
+	}
+	if b := testGit(t, "branch", "--show-current"); b != "master" {
+		t.Error(b)
+	}
+	if h := testGit(t, "rev-parse", "unrelated"); h != unrelated {
+		t.Error(h)
+	}
+	if s := testGit(t, "status", "--porcelain"); s != "" {
+		t.Error(s)
+	}
+}
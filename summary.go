@@ -0,0 +1,87 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"sync"
+	"text/tabwriter"
+)
+
+// what has been done to a branch
+type result struct {
+	branch string
+	action string
+	old    string
+	head   string
+	gained int
+}
+
+var (
+	results []result
+	// the results are recorded by several goroutines with -j
+	resultsMutex sync.Mutex
+)
+
+// it replaces the previous result of the branch, i.e. when it is deleted
+func recordResult(r result) {
+	resultsMutex.Lock()
+	defer resultsMutex.Unlock()
+	for i := range results {
+		if results[i].branch == r.branch {
+			results[i] = r
+			return
+		}
+	}
+	results = append(results, r)
+}
+
+// it finds out how the branch has been pulled
+func recordPull(branch, old, head string) {
+	r := result{branch: branch, old: old, head: head}
+	if old == head {
+		r.action = "up to date"
+	} else if isAncestor(old, head) {
+		r.action = "fast-forwarded"
+		if parents, err := gitOutput("rev-list", "--parents", "-n", "1", head); err == nil {
+			if p := strings.Fields(parents); len(p) > 2 && p[1] == old {
+				r.action = "merged"
+			}
+		}
+	} else {
+		r.action = "rebased"
+	}
+	// the local commits of a rebase are rewritten but they are not gained
+	if old != head {
+		if count, err := gitOutput("rev-list", "--count", "--right-only",
+			"--cherry-pick", old+"..."+head); err == nil {
+			fmt.Sscan(count, &r.gained)
+		}
+	}
+	recordResult(r)
+}
+
+func shortHash(hash string) string {
+	if hash == "" {
+		return "-"
+	} else if len(hash) > 7 {
+		return hash[:7]
+	}
+	return hash
+}
+
+// a table of the results, empty if there are none
+func formatResults(results []result) string {
+	if len(results) == 0 {
+		return ""
+	}
+	var b bytes.Buffer
+	w := tabwriter.NewWriter(&b, 0, 8, 2, ' ', 0)
+	fmt.Fprintf(w, "branch\taction\tcommits\tgained\n")
+	for _, r := range results {
+		fmt.Fprintf(w, "%s\t%s\t%s -> %s\t%d\n", r.branch, r.action,
+			shortHash(r.old), shortHash(r.head), r.gained)
+	}
+	w.Flush()
+	return b.String()
+}
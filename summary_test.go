@@ -0,0 +1,84 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"testing"
+)
+
+func TestFormatResults(t *testing.T) {
+	if s := formatResults(nil); s != "" {
+		t.Error(s)
+	}
+	s := bufio.NewScanner(bytes.NewBufferString(formatResults([]result{
+		{"master", "up to date", "0123456789", "0123456789", 0},
+		{"foo", "rebased", "abcdef0123", "9876543210", 2},
+		{"bar", "deleted", "fedcba9876", "", 0},
+	})))
+	if v := s.Scan(); !v {
+		t.Fatal(v)
+	}
+	if e := s.Text(); e != "branch  action      commits             gained" {
+		t.Error(e)
+	}
+	if v := s.Scan(); !v {
+		t.Fatal(v)
+	}
+	if e := s.Text(); e != "master  up to date  0123456 -> 0123456  0" {
+		t.Error(e)
+	}
+	if v := s.Scan(); !v {
+		t.Fatal(v)
+	}
+	if e := s.Text(); e != "foo     rebased     abcdef0 -> 9876543  2" {
+		t.Error(e)
+	}
+	if v := s.Scan(); !v {
+		t.Fatal(v)
+	}
+	if e := s.Text(); e != "bar     deleted     fedcba9 -> -        0" {
+		t.Error(e)
+	}
+	if v := s.Scan(); v {
+		t.Fatal(v)
+	}
+}
+
+func TestRecordResultDeleted(t *testing.T) {
+	old := results
+	defer func() {
+		results = old
+	}()
+	results = nil
+	recordResult(result{"foo", "rebased", "a", "b", 1})
+	recordResult(result{"bar", "up to date", "c", "c", 0})
+	recordResult(result{branch: "foo", action: "deleted", old: "b"})
+	if l := len(results); l != 2 {
+		t.Fatal(l)
+	}
+	if r := results[0]; r != (result{"foo", "deleted", "b", "", 0}) {
+		t.Error(r)
+	}
+}
+
+func TestRecordPullRebased(t *testing.T) {
+	newTestRepository(t)
+	old := results
+	defer func() {
+		results = old
+	}()
+	results = nil
+	testGit(t, "checkout", "-q", "-b", "topic", "-t", "master")
+	testCommit(t, "a")
+	testCommit(t, "b")
+	testGit(t, "checkout", "-q", "master")
+	testCommit(t, "c")
+	topic := testGit(t, "rev-parse", "topic")
+	testGit(t, "rebase", "-q", "master", "topic")
+	head := testGit(t, "rev-parse", "topic")
+	recordPull("topic", topic, head)
+	// only the commit of master is new in topic
+	if r := results[0]; r != (result{"topic", "rebased", topic, head, 1}) {
+		t.Error(r)
+	}
+}
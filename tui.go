@@ -0,0 +1,410 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"unicode/utf8"
+)
+
+const tuiHelp = "j/k move  c checkout  u update  d delete  " +
+	"a/r add/remove upstream  D diff  q quit"
+
+// state of the terminal user interface
+type tui struct {
+	g *graph
+	// branches in the order of the lines of the graph
+	nodes []*node
+	// lines of the log graph and index of the line of every branch
+	lines []string
+	rows  []int
+	// index of the selected branch in nodes
+	selected int
+	current  string
+	// output of the last action
+	message string
+	// settings of the terminal before the raw mode
+	stty string
+}
+
+// it shows the graph in the whole terminal, the keys of tuiHelp act on the
+// selected branch with the same functions as the other commands
+func tuiGraph(args []string) (err error) {
+	if len(args) != 0 {
+		err = fmt.Errorf("usage: tui")
+		return
+	}
+	t := &tui{}
+	if t.stty, err = stty("-g"); err != nil {
+		err = fmt.Errorf("tui needs a terminal: %w", err)
+		return
+	}
+	if err = t.load(); err != nil {
+		return
+	}
+	if err = t.resume(); err != nil {
+		return
+	}
+	defer func() {
+		if e := t.suspend(); err == nil {
+			err = e
+		}
+	}()
+	keys := bufio.NewReader(os.Stdin)
+	for {
+		t.draw()
+		var key string
+		if key, err = readKey(keys); err != nil {
+			return
+		}
+		switch key {
+		case "q", "\x03":
+			return
+		case "j", "\x1b[B":
+			if t.selected < len(t.nodes)-1 {
+				t.selected++
+			}
+			t.message = ""
+		case "k", "\x1b[A":
+			if t.selected > 0 {
+				t.selected--
+			}
+			t.message = ""
+		case "c", "u", "d", "a", "r", "D":
+			if err = t.act(key, keys); err != nil {
+				return
+			}
+		}
+	}
+}
+
+// it reads a key or an escape sequence of the arrows
+func readKey(r *bufio.Reader) (key string, err error) {
+	var b byte
+	if b, err = r.ReadByte(); err != nil {
+		return
+	}
+	key = string(b)
+	if b == '\x1b' && r.Buffered() >= 2 {
+		seq := make([]byte, 2)
+		if _, err = r.Read(seq); err != nil {
+			return
+		}
+		key += string(seq)
+	}
+	return
+}
+
+// it builds the graph again, the selection stays on the same branch if it still
+// exists
+func (t *tui) load() (err error) {
+	var selected ref
+	if t.selected < len(t.nodes) {
+		selected = t.nodes[t.selected].ref
+	}
+	if t.g, err = fillGraphForAllBranches(); err != nil {
+		return
+	}
+	fillPushTargets(t.g)
+	_, t.current, _ = getSymbolicFullNames("HEAD")
+	t.nodes = t.g.logOrder()
+	t.lines = strings.Split(strings.TrimSuffix(t.g.log(t.current,
+		currentColorCode, remoteColorCode, resetColorCode), "\n"), "\n")
+	t.rows = t.rows[:0]
+	for i, l := range t.lines {
+		// only the lines of the branches have a star
+		if strings.Contains(l, "*") {
+			t.rows = append(t.rows, i)
+		}
+	}
+	t.selected = 0
+	for i, n := range t.nodes {
+		if n.ref == selected {
+			t.selected = i
+		}
+	}
+	return
+}
+
+func (t *tui) draw() {
+	fmt.Print(t.screen(terminalSize()))
+}
+
+// the contents of the terminal of the size, every line is truncated to width
+func (t *tui) screen(height, width int) (s string) {
+	var details []string
+	if len(t.nodes) > 0 {
+		details = t.details(t.nodes[t.selected])
+	}
+	if t.message != "" {
+		details = append(details, "", t.message)
+	}
+	// the lines of the graph that fit above the details, the selected one is
+	// always visible
+	view := height - len(details) - 3
+	if view < 1 {
+		view = 1
+	}
+	first := 0
+	if len(t.rows) > 0 && t.rows[t.selected] >= view {
+		first = t.rows[t.selected] - view + 1
+	}
+	s = "\x1b[H\x1b[2J" + truncate(tuiHelp, width) + "\r\n\r\n"
+	for i := first; i < len(t.lines) && i < first+view; i++ {
+		line := truncate(t.lines[i], width)
+		if len(t.rows) > 0 && i == t.rows[t.selected] {
+			// the colors of the line reset the reverse video too
+			if resetColorCode != "" {
+				line = strings.ReplaceAll(line, resetColorCode, resetColorCode+"\x1b[7m")
+			}
+			s += "\x1b[7m" + line + "\x1b[0m\r\n"
+		} else {
+			s += line + "\r\n"
+		}
+	}
+	s += "\r\n"
+	for _, d := range details {
+		s += truncate(d, width) + "\r\n"
+	}
+	return
+}
+
+// the commits ahead and behind every upstream and the last commits of n
+func (t *tui) details(n *node) (lines []string) {
+	lines = append(lines, n.branch)
+	var upstreams nodesort
+	for u := range n.upstreams {
+		upstreams = append(upstreams, u)
+	}
+	sort.Sort(&upstreams)
+	for _, u := range upstreams {
+		if ahead, behind, err := getAheadBehind(n.branch, u.branch); err == nil {
+			lines = append(lines, fmt.Sprintf("  %v: ahead %v, behind %v", u.branch,
+				ahead, behind))
+		}
+	}
+	for _, merge := range n.gone {
+		lines = append(lines, fmt.Sprintf("  %v: gone", merge))
+	}
+	if log, err := gitOutput("log", "-5", "--format=%h %s", n.branch,
+		"--"); err == nil {
+		for _, l := range strings.Split(log, "\n") {
+			lines = append(lines, "  "+l)
+		}
+	}
+	return
+}
+
+// it runs the action of the key on the selected branch outside of the full
+// screen and waits for a key to return
+func (t *tui) act(key string, keys *bufio.Reader) (err error) {
+	if len(t.nodes) == 0 {
+		return
+	}
+	n := t.nodes[t.selected]
+	if err = t.suspend(); err != nil {
+		return
+	}
+	var e error
+	switch key {
+	case "c":
+		e = checkoutBranchIfNeeded(n.branch, &t.current)
+	case "u":
+		e = t.update(n)
+	case "d":
+		e = t.delete(n)
+	case "a", "r":
+		var upstream string
+		fmt.Printf("%v upstream of %v: ", map[string]string{"a": "add",
+			"r": "remove"}[key], n.branch)
+		if upstream, e = keys.ReadString('\n'); e == nil {
+			if key == "a" {
+				e = t.addUpstream(n, strings.TrimSpace(upstream))
+			} else {
+				e = t.rmUpstream(n, strings.TrimSpace(upstream))
+			}
+		}
+	case "D":
+		e = t.diff(n)
+	}
+	if e != nil {
+		t.message = e.Error()
+	} else {
+		t.message = ""
+	}
+	fmt.Print("press a key to continue")
+	if err = t.resume(); err != nil {
+		return
+	}
+	if _, err = readKey(keys); err != nil {
+		return
+	}
+	return t.load()
+}
+
+// it pulls n and the branches that depend on it and then it returns to the
+// current branch
+func (t *tui) update(n *node) (err error) {
+	fullcurrent, back, _ := getSymbolicFullNames("HEAD")
+	updateGrebHeadRef(fullcurrent)
+	nodes := t.g.downstreamsOf(n)
+	if len(n.upstreams) > 0 {
+		nodes = append([]*node{n}, nodes...)
+	}
+	if _, err = pullBranches(nodes, &t.current); err != nil {
+		return
+	}
+	if back != "" {
+		err = checkoutBranchIfNeeded(back, &t.current)
+	}
+	return
+}
+
+func (t *tui) delete(n *node) (err error) {
+	if n.remote != "." {
+		err = fmt.Errorf("%s is not a local branch", n.branch)
+		return
+	}
+	_, back, _ := getSymbolicFullNames("HEAD")
+	if err = deleteBranchIfMerged(t.g, n, &back, &t.current); err != nil {
+		return
+	}
+	if _, e := revParse(n.branch); e == nil {
+		err = fmt.Errorf("%s is not merged into its upstream branches", n.branch)
+	}
+	return
+}
+
+// it makes n track the local branch upstream
+func (t *tui) addUpstream(n *node, upstream string) (err error) {
+	var refname, branch string
+	if refname, branch, err = getSymbolicFullNames(upstream); err != nil {
+		return
+	}
+	u, ok := t.g.nodes[ref{refname, "."}]
+	if branch == "" || !ok {
+		err = fmt.Errorf("%s is not a local branch", upstream)
+		return
+	}
+	if n.remote != "." {
+		err = fmt.Errorf("%s is not a local branch", n.branch)
+		return
+	}
+	for _, d := range append(t.g.downstreamsOf(n), n) {
+		if d == u {
+			err = fmt.Errorf("%s depends on %s", upstream, n.branch)
+			return
+		}
+	}
+	var updates []interface{}
+	if hasRemoteUpstreams(n) {
+		err = fmt.Errorf("%s has upstream branches in other repositories",
+			n.branch)
+		return
+	} else if len(n.upstreams) == 0 {
+		updates = append(updates, setRemote{n.branch, "."})
+	}
+	updates = append(updates, addUpstream{n.branch, refname})
+	return applyUpdates(updates)
+}
+
+// it makes n stop tracking the branch upstream
+func (t *tui) rmUpstream(n *node, upstream string) (err error) {
+	for u, merge := range n.upstreams {
+		if u.branch == upstream || merge == upstream {
+			return applyUpdates([]interface{}{rmUpstream{n.branch, merge}})
+		}
+	}
+	err = fmt.Errorf("%s is not an upstream branch of %s", upstream, n.branch)
+	return
+}
+
+// it shows the changes of n over its upstreams with the pager of git
+func (t *tui) diff(n *node) (err error) {
+	if len(n.upstreams) == 0 {
+		err = fmt.Errorf("%s does not have upstream branches", n.branch)
+		return
+	}
+	var upstreams nodesort
+	for u := range n.upstreams {
+		upstreams = append(upstreams, u)
+	}
+	sort.Sort(&upstreams)
+	for _, u := range upstreams {
+		cmd := newCommand(!quiet, true, "git", "diff", u.branch+"..."+n.branch)
+		cmd.Stdin = os.Stdin
+		if err = runCommand(cmd); err != nil {
+			return
+		}
+	}
+	return
+}
+
+// it leaves the full screen and restores the terminal
+func (t *tui) suspend() (err error) {
+	fmt.Print("\x1b[?25h\x1b[?1049l")
+	_, err = stty(t.stty)
+	return
+}
+
+// it enters the full screen without echo or line buffering
+func (t *tui) resume() (err error) {
+	if _, err = stty("raw", "-echo"); err != nil {
+		return
+	}
+	fmt.Print("\x1b[?1049h\x1b[?25l")
+	return
+}
+
+// it runs stty on the terminal of the standard input
+func stty(args ...string) (output string, err error) {
+	cmd := newCommand(verbose, false, "stty", args...)
+	cmd.Stdin = os.Stdin
+	var o []byte
+	if o, err = cmd.Output(); err != nil {
+		err = cmdError(cmd, err)
+		return
+	}
+	output = strings.TrimSpace(string(o))
+	return
+}
+
+// rows and columns of the terminal, 24x80 if they are not known
+func terminalSize() (height, width int) {
+	height, width = 24, 80
+	var h, w int
+	if size, err := stty("size"); err == nil {
+		if _, err = fmt.Sscan(size, &h, &w); err == nil && h > 0 && w > 0 {
+			height, width = h, w
+		}
+	}
+	return
+}
+
+// the line cut to the width of the terminal, the escape sequences of the colors
+// do not count
+func truncate(line string, width int) string {
+	var s strings.Builder
+	n := 0
+	for i := 0; i < len(line); {
+		if line[i] == '\x1b' {
+			j := strings.IndexByte(line[i:], 'm')
+			if j < 0 {
+				break
+			}
+			s.WriteString(line[i : i+j+1])
+			i += j + 1
+			continue
+		}
+		// a character may take several bytes
+		_, size := utf8.DecodeRuneInString(line[i:])
+		if n < width {
+			s.WriteString(line[i : i+size])
+		}
+		n++
+		i += size
+	}
+	return s.String()
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"bufio"
+	"strings"
+	"testing"
+)
+
+func TestTruncate(t *testing.T) {
+	if s := truncate("* \x1b[32mmaster\x1b[m [gone]", 5); s != "* \x1b[32mmas\x1b[m" {
+		t.Errorf("%q", s)
+	}
+	if s := truncate("* foo", 80); s != "* foo" {
+		t.Errorf("%q", s)
+	}
+	if s := truncate("* \x1b[32mñandú\x1b[m", 5); s != "* \x1b[32mñan\x1b[m" {
+		t.Errorf("%q", s)
+	}
+}
+
+func TestReadKey(t *testing.T) {
+	r := bufio.NewReader(strings.NewReader("j\x1b[Aq"))
+	for _, e := range []string{"j", "\x1b[A", "q"} {
+		if k, err := readKey(r); err != nil || k != e {
+			t.Errorf("%q %v", k, err)
+		}
+	}
+}
+
+func TestTuiScreen(t *testing.T) {
+	// the log of the details fails outside of a repository
+	t.Chdir(t.TempDir())
+	old := resetColorCode
+	defer func() {
+		resetColorCode = old
+	}()
+	resetColorCode = "\x1b[m"
+	g := newGraph()
+	a, _ := g.node(ref{"a", "."})
+	b, _ := g.node(ref{"b", "."})
+	a.branch, b.branch = "a", "b"
+	tu := &tui{g: g, nodes: []*node{a, b}, selected: 1,
+		lines: []string{"* \x1b[32mlong-branch\x1b[m", "| * \x1b[31mother\x1b[m"},
+		rows:  []int{0, 1}}
+	s := tu.screen(24, 5)
+	lines := strings.Split(s, "\r\n")
+	if l := lines[2]; l != "* \x1b[32mlon\x1b[m" {
+		t.Errorf("%q", l)
+	}
+	if l := lines[3]; l != "\x1b[7m| * \x1b[31mo\x1b[m\x1b[7m\x1b[0m" {
+		t.Errorf("%q", l)
+	}
+}
@@ -0,0 +1,274 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// the time to wait for the rest of the changes of a git command
+const watchDelay = 200 * time.Millisecond
+
+// it waits for changes of the refs and updates the downstreams of the branches
+// that move without checking them out, until it is interrupted
+func watchBranches(args []string) (err error) {
+	var interval time.Duration
+	fs := flag.NewFlagSet("watch", flag.ContinueOnError)
+	fs.DurationVar(&interval, "fetch", 0,
+		"the interval to fetch the remotes, never by default")
+	if args, err = parseSubcommand(fs, args); err != nil {
+		return
+	}
+	if len(args) != 0 {
+		err = fmt.Errorf("usage: watch [-fetch <interval>]")
+		return
+	}
+	var dir string
+	if dir, err = gitOutput("rev-parse", "--git-common-dir"); err != nil {
+		return
+	}
+	if dir, err = filepath.Abs(dir); err != nil {
+		return
+	}
+	var w *watcher
+	if w, err = newWatcher(dir); err != nil {
+		return
+	}
+	defer w.close()
+	restore, stop := notifyInterrupt()
+	defer func() {
+		stop()
+		if isInterrupted(err) {
+			err = nil
+		}
+		restore()
+	}()
+	if verbose {
+		logPrintf("-> watching %s\n", dir)
+	}
+	var refs map[string]string
+	if refs, err = readRefs(); err != nil {
+		return
+	}
+	// the remotes are fetched at a fixed interval, the changes do not delay it
+	fetch := time.Now().Add(interval)
+	for {
+		var timeout time.Duration
+		if interval > 0 {
+			// a zero timeout would never fetch again
+			if timeout = time.Until(fetch); timeout <= 0 {
+				timeout = time.Millisecond
+			}
+		}
+		if _, err = w.wait(timeout); err != nil || ctx.Err() != nil {
+			return
+		}
+		if interval > 0 && !time.Now().Before(fetch) {
+			fetch = time.Now().Add(interval)
+			cmd := newCommand(!quiet, true, "git", "fetch", "--all")
+			if e := runCommand(cmd); e != nil {
+				logPrintf("%s\n", e)
+			}
+		}
+		var next map[string]string
+		if next, err = readRefs(); err != nil {
+			return
+		}
+		moved := movedRefs(refs, next)
+		if len(moved) > 0 {
+			var g *graph
+			if g, err = fillGraphForAllBranches(); err != nil {
+				return
+			}
+			if err = updateDownstreams(g, moved); err != nil {
+				return
+			}
+			// the branches updated are not changes
+			if next, err = readRefs(); err != nil {
+				return
+			}
+		}
+		refs = next
+	}
+}
+
+// the hashes of the local and remote-tracking branches
+func readRefs() (refs map[string]string, err error) {
+	var output string
+	if output, err = gitOutput("for-each-ref",
+		"--format=%(refname) %(objectname)", refsHeads, refsRemotes); err != nil {
+		return
+	}
+	refs = make(map[string]string)
+	for _, l := range strings.Split(output, "\n") {
+		if f := strings.Fields(l); len(f) == 2 {
+			refs[f[0]] = f[1]
+		}
+	}
+	return
+}
+
+// the refs that have changed or been created
+func movedRefs(old, refs map[string]string) (moved map[string]struct{}) {
+	moved = make(map[string]struct{})
+	for r, hash := range refs {
+		if old[r] != hash {
+			moved[r] = struct{}{}
+		}
+	}
+	return
+}
+
+// it updates the branches with an upstream in moved, the branches updated are
+// added to moved, the branches checked out in any worktree are not updated
+func updateDownstreams(g *graph, moved map[string]struct{}) (err error) {
+	var checkedOut map[string]struct{}
+	if checkedOut, err = worktreeBranches(); err != nil {
+		return
+	}
+	for _, n := range g.sort() {
+		if ctx.Err() != nil {
+			err = errInterrupted
+			return
+		}
+		var upstreams nodesort
+		found := false
+		for u := range n.upstreams {
+			upstreams = append(upstreams, u)
+			name := u.name
+			if u.remote != "." {
+				name = refsRemotes + u.branch
+			}
+			if _, ok := moved[name]; ok {
+				found = true
+			}
+		}
+		if !found || (local && hasRemoteUpstreams(n)) {
+			continue
+		}
+		if _, ok := checkedOut[n.name]; ok {
+			fmt.Printf("%s: checked out, not updated\n", n.branch)
+			continue
+		}
+		sort.Sort(&upstreams)
+		var updated bool
+		if updated, err = updateBranchInPlace(n, upstreams); err != nil {
+			return
+		} else if updated {
+			moved[n.name] = struct{}{}
+		}
+	}
+	return
+}
+
+// it fast-forwards, rebases or merges the branch onto its upstreams as git pull
+// but without a worktree, the branch is not updated if there are conflicts
+func updateBranchInPlace(n *node, upstreams []*node) (updated bool, err error) {
+	var head string
+	if head, err = revParse(n.name); err != nil {
+		return
+	}
+	var heads, names []string
+	uptodate := true
+	for _, u := range upstreams {
+		var h string
+		if h, err = revParse(u.branch); err != nil {
+			return
+		}
+		heads, names = append(heads, h), append(names, u.branch)
+		uptodate = uptodate && isAncestor(h, head)
+	}
+	if uptodate {
+		return
+	}
+	var hash, action string
+	var files []string
+	if len(heads) == 1 && isAncestor(head, heads[0]) {
+		hash, action = heads[0], "fast-forwarded"
+	} else if len(heads) == 1 && isRebase(n) {
+		// the name of the upstream for the fork point in its reflog
+		hash, files, err = replayCommits(head, names[0], pickCommit)
+		action = "rebased"
+	} else {
+		branches := make(map[string]string, len(heads))
+		for i, h := range heads {
+			branches[h] = names[i]
+		}
+		hash, files, err = mergeHeads(head, heads, func(tree string,
+			parents ...string) (string, error) {
+			return commitTreeWithMessage(fmt.Sprintf("Merge branch '%s' into %s",
+				branches[parents[1]], n.branch), tree, parents...)
+		})
+		action = "merged"
+	}
+	if err != nil {
+		return
+	}
+	if len(files) > 0 {
+		fmt.Printf("%s: conflicts in %s, not updated\n", n.branch,
+			strings.Join(files, ", "))
+		return
+	}
+	if hash, err = revParse(hash); err != nil {
+		return
+	}
+	cmd := newCommand(!quiet, true, "git", "update-ref", "-m", "greb watch: "+
+		action, n.name, hash, head)
+	if err = runCommand(cmd); err != nil {
+		return
+	}
+	emitEvent(event{Event: "updated", Branch: n.branch, Old: head, New: hash})
+	fmt.Printf("%s: %s %s, %s\n", n.branch, action, strings.Join(names, ", "),
+		shortHash(hash))
+	updated = true
+	return
+}
+
+// it creates a commit with the tree on top of the parent with the author and
+// the message of c
+func pickCommit(tree, parent, c string) (commit string, err error) {
+	var info string
+	if info, err = gitOutput("log", "-1", "--date=raw",
+		"--format=%an%x00%ae%x00%ad%x00%B", c); err != nil {
+		return
+	}
+	f := strings.SplitN(info, "\x00", 4)
+	if len(f) != 4 {
+		err = fmt.Errorf("%s: invalid commit", c)
+		return
+	}
+	cmd := newCommand(verbose, false, "git", "commit-tree", tree, "-p", parent,
+		"-F", "-")
+	cmd.Env = append(os.Environ(), "GIT_AUTHOR_NAME="+f[0],
+		"GIT_AUTHOR_EMAIL="+f[1], "GIT_AUTHOR_DATE="+f[2])
+	cmd.Stdin = strings.NewReader(f[3] + "\n")
+	var output []byte
+	if output, err = cmd.Output(); err != nil {
+		err = cmdError(cmd, err)
+		return
+	}
+	commit = strings.TrimSpace(string(output))
+	if verbose {
+		logPrintf("-> %s\n", commit)
+	}
+	return
+}
+
+// the full names of the branches checked out in the worktrees
+func worktreeBranches() (branches map[string]struct{}, err error) {
+	var output string
+	if output, err = gitOutput("worktree", "list", "--porcelain"); err != nil {
+		return
+	}
+	branches = make(map[string]struct{})
+	for _, l := range strings.Split(output, "\n") {
+		if strings.HasPrefix(l, "branch ") {
+			branches[strings.TrimPrefix(l, "branch ")] = struct{}{}
+		}
+	}
+	return
+}
@@ -0,0 +1,185 @@
+package main
+
+import (
+	"io/fs"
+	"os"
+	"path/filepath"
+	"syscall"
+	"time"
+	"unsafe"
+)
+
+const watchMask = syscall.IN_CREATE | syscall.IN_DELETE | syscall.IN_MODIFY |
+	syscall.IN_MOVED_FROM | syscall.IN_MOVED_TO
+
+// it waits for changes in the git directory with inotify
+type watcher struct {
+	fd  int
+	dir string
+	// the watch of the git directory, only packed-refs matters in it
+	root int
+	// close writes in the pipe to stop read
+	pipe   [2]int
+	epfd   int
+	events chan struct{}
+	errs   chan error
+	done   chan struct{}
+}
+
+func newWatcher(dir string) (w *watcher, err error) {
+	w = &watcher{fd: -1, dir: dir, pipe: [2]int{-1, -1}, epfd: -1,
+		events: make(chan struct{}, 1), errs: make(chan error, 1),
+		done: make(chan struct{})}
+	defer func() {
+		if err != nil {
+			w.closeFds()
+			w = nil
+		}
+	}()
+	if w.fd, err = syscall.InotifyInit1(syscall.IN_CLOEXEC |
+		syscall.IN_NONBLOCK); err != nil {
+		err = os.NewSyscallError("inotify_init1", err)
+		return
+	}
+	if err = syscall.Pipe2(w.pipe[:], syscall.O_CLOEXEC|
+		syscall.O_NONBLOCK); err != nil {
+		err = os.NewSyscallError("pipe2", err)
+		return
+	}
+	if w.epfd, err = syscall.EpollCreate1(syscall.EPOLL_CLOEXEC); err != nil {
+		err = os.NewSyscallError("epoll_create1", err)
+		return
+	}
+	for _, fd := range []int{w.fd, w.pipe[0]} {
+		e := syscall.EpollEvent{Events: syscall.EPOLLIN, Fd: int32(fd)}
+		if err = syscall.EpollCtl(w.epfd, syscall.EPOLL_CTL_ADD, fd,
+			&e); err != nil {
+			err = os.NewSyscallError("epoll_ctl", err)
+			return
+		}
+	}
+	// git replaces packed-refs, the file itself cannot be watched
+	if w.root, err = syscall.InotifyAddWatch(w.fd, dir, watchMask); err != nil {
+		err = os.NewSyscallError("inotify_add_watch", err)
+		return
+	}
+	if err = w.add(); err != nil {
+		return
+	}
+	go w.read()
+	return
+}
+
+// it watches every directory of refs, the new ones too if it is called again
+func (w *watcher) add() (err error) {
+	return filepath.WalkDir(filepath.Join(w.dir, "refs"), func(path string,
+		d fs.DirEntry, err error) error {
+		if err != nil || !d.IsDir() {
+			return err
+		}
+		if _, err = syscall.InotifyAddWatch(w.fd, path, watchMask); err != nil {
+			return os.NewSyscallError("inotify_add_watch", err)
+		}
+		return nil
+	})
+}
+
+// it reads the events until close writes in the pipe
+func (w *watcher) read() {
+	defer close(w.done)
+	buf := make([]byte, 64*(syscall.SizeofInotifyEvent+syscall.NAME_MAX+1))
+	events := make([]syscall.EpollEvent, 2)
+	for {
+		n, err := syscall.EpollWait(w.epfd, events, -1)
+		if err == syscall.EINTR {
+			continue
+		} else if err != nil {
+			w.errs <- os.NewSyscallError("epoll_wait", err)
+			return
+		}
+		for _, e := range events[:n] {
+			if int(e.Fd) == w.pipe[0] {
+				return
+			}
+		}
+		changed := false
+		for {
+			n, err = syscall.Read(w.fd, buf)
+			if err == syscall.EINTR {
+				continue
+			} else if err == syscall.EAGAIN {
+				break
+			} else if err != nil {
+				w.errs <- os.NewSyscallError("read", err)
+				return
+			}
+			if w.changed(buf[:n]) {
+				changed = true
+			}
+		}
+		if !changed {
+			continue
+		}
+		select {
+		case w.events <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// whether the inotify events in buf are about refs or packed-refs
+func (w *watcher) changed(buf []byte) bool {
+	for i := 0; i+syscall.SizeofInotifyEvent <= len(buf); {
+		e := (*syscall.InotifyEvent)(unsafe.Pointer(&buf[i]))
+		i += syscall.SizeofInotifyEvent
+		name := buf[i : i+int(e.Len)]
+		i += int(e.Len)
+		// the names are padded with zeros
+		for len(name) > 0 && name[len(name)-1] == 0 {
+			name = name[:len(name)-1]
+		}
+		if int(e.Wd) != w.root || string(name) == "packed-refs" {
+			return true
+		}
+	}
+	return false
+}
+
+// it returns whether there have been changes before the timeout, if any, or
+// the interrupt
+func (w *watcher) wait(timeout time.Duration) (changed bool, err error) {
+	var timer <-chan time.Time
+	if timeout > 0 {
+		timer = time.After(timeout)
+	}
+	select {
+	case <-w.events:
+		// git changes several files for every command
+		time.Sleep(watchDelay)
+		select {
+		case <-w.events:
+		default:
+		}
+		changed = true
+		err = w.add()
+	case err = <-w.errs:
+	case <-timer:
+	case <-ctx.Done():
+	}
+	return
+}
+
+// it stops read and closes the descriptors once read has returned
+func (w *watcher) close() {
+	syscall.Write(w.pipe[1], []byte{0})
+	<-w.done
+	w.closeFds()
+}
+
+func (w *watcher) closeFds() {
+	for _, fd := range []int{w.fd, w.pipe[0], w.pipe[1], w.epfd} {
+		if fd >= 0 {
+			syscall.Close(fd)
+		}
+	}
+}
@@ -0,0 +1,79 @@
+//go:build !linux
+
+package main
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// it waits for changes in the git directory polling the modification times
+type watcher struct {
+	dir   string
+	state string
+}
+
+func newWatcher(dir string) (w *watcher, err error) {
+	w = &watcher{dir: dir}
+	w.state, err = w.read()
+	return
+}
+
+// the names and modification times of packed-refs and the files and
+// directories of refs
+func (w *watcher) read() (state string, err error) {
+	if info, e := os.Stat(filepath.Join(w.dir, "packed-refs")); e == nil {
+		state += fmt.Sprintf("packed-refs %v\n", info.ModTime().UnixNano())
+	}
+	err = filepath.WalkDir(filepath.Join(w.dir, "refs"), func(path string,
+		d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		state += fmt.Sprintf("%v %v\n", path, info.ModTime().UnixNano())
+		return nil
+	})
+	return
+}
+
+// it returns whether there have been changes before the timeout, if any, or
+// the interrupt
+func (w *watcher) wait(timeout time.Duration) (changed bool, err error) {
+	var timer <-chan time.Time
+	if timeout > 0 {
+		timer = time.After(timeout)
+	}
+	tick := time.NewTicker(time.Second)
+	defer tick.Stop()
+	for {
+		select {
+		case <-tick.C:
+		case <-timer:
+			return
+		case <-ctx.Done():
+			return
+		}
+		var state string
+		if state, err = w.read(); err != nil {
+			return
+		}
+		if state != w.state {
+			w.state = state
+			// git changes several files for every command
+			time.Sleep(watchDelay)
+			w.state, err = w.read()
+			changed = true
+			return
+		}
+	}
+}
+
+func (w *watcher) close() {
+}
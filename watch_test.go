@@ -0,0 +1,96 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestMovedRefs(t *testing.T) {
+	moved := movedRefs(map[string]string{
+		"refs/heads/a": "1",
+		"refs/heads/b": "2",
+		"refs/heads/c": "3",
+	}, map[string]string{
+		"refs/heads/a": "1",
+		"refs/heads/b": "4",
+		"refs/heads/d": "5",
+	})
+	if len(moved) != 2 {
+		t.Error(moved)
+	}
+	for _, r := range []string{"refs/heads/b", "refs/heads/d"} {
+		if _, ok := moved[r]; !ok {
+			t.Error(r)
+		}
+	}
+}
+
+func TestWatcher(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(dir, "refs", "heads"), 0777); err != nil {
+		t.Fatal(err)
+	}
+	w, err := newWatcher(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.close()
+	if changed, err := w.wait(100 * time.Millisecond); err != nil || changed {
+		t.Error(changed, err)
+	}
+	// the directories created after the watcher are watched too
+	if err := os.MkdirAll(filepath.Join(dir, "refs", "heads", "a"), 0777); err != nil {
+		t.Fatal(err)
+	}
+	if changed, err := w.wait(5 * time.Second); err != nil || !changed {
+		t.Error(changed, err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "refs", "heads", "a", "b"), nil,
+		0666); err != nil {
+		t.Fatal(err)
+	}
+	if changed, err := w.wait(5 * time.Second); err != nil || !changed {
+		t.Error(changed, err)
+	}
+	// only packed-refs matters in the git directory
+	if err := os.WriteFile(filepath.Join(dir, "config"), nil, 0666); err != nil {
+		t.Fatal(err)
+	}
+	if changed, err := w.wait(100 * time.Millisecond); err != nil || changed {
+		t.Error(changed, err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "packed-refs.lock"), nil,
+		0666); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Rename(filepath.Join(dir, "packed-refs.lock"),
+		filepath.Join(dir, "packed-refs")); err != nil {
+		t.Fatal(err)
+	}
+	if changed, err := w.wait(5 * time.Second); err != nil || !changed {
+		t.Error(changed, err)
+	}
+}
+
+func TestWatcherClose(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.Mkdir(filepath.Join(dir, "refs"), 0777); err != nil {
+		t.Fatal(err)
+	}
+	w, err := newWatcher(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	done := make(chan struct{})
+	go func() {
+		w.close()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Error("close is blocked")
+	}
+}
@@ -0,0 +1,201 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"text/tabwriter"
+)
+
+// it runs greb with the same options and arguments in every repository of the
+// workspace, up to jobs at the same time, and prints a summary of all of them
+func grebWorkspace(args []string) (err error) {
+	var repos []string
+	if repos, err = findRepositories(workspace); err != nil {
+		return
+	}
+	if len(repos) == 0 {
+		err = fmt.Errorf("no repositories in %s", workspace)
+		return
+	}
+	var exe string
+	if exe, err = os.Executable(); err != nil {
+		return
+	}
+	// the workspace of git config is not read again
+	args = append([]string{"-workspace="}, childArgs(args, "workspace",
+		"keep-going", "j")...)
+	restore, stop := notifyInterrupt()
+	defer func() {
+		stop()
+		restore()
+	}()
+	started := make([]bool, len(repos))
+	done := make([]bool, len(repos))
+	errs := make([]error, len(repos))
+	outputs := make([]*bytes.Buffer, len(repos))
+	finished := make(chan int)
+	running, printed := 0, 0
+	var failed []string
+	for {
+		for i := range repos {
+			if running == jobs || (len(failed) > 0 && !keepGoing) ||
+				ctx.Err() != nil {
+				break
+			}
+			if !started[i] {
+				started[i] = true
+				running++
+				go func(i int) {
+					outputs[i], errs[i] = runInRepository(repos[i], exe, args)
+					finished <- i
+				}(i)
+			}
+		}
+		if running == 0 {
+			break
+		}
+		i := <-finished
+		running--
+		done[i] = true
+		if errs[i] != nil {
+			failed = append(failed, repos[i])
+		}
+		for ; printed < len(repos) && done[printed]; printed++ {
+			commandOutput.Write(outputs[printed].Bytes())
+		}
+	}
+	for ; printed < len(repos); printed++ {
+		if done[printed] {
+			commandOutput.Write(outputs[printed].Bytes())
+		}
+	}
+	fmt.Fprint(commandOutput, formatWorkspace(repos, done, errs))
+	if ctx.Err() != nil {
+		err = errInterrupted
+	} else if len(failed) > 0 {
+		err = fmt.Errorf("failed repositories: %s", strings.Join(failed, ", "))
+	}
+	return
+}
+
+// the repositories in the list file or in the directory and its
+// subdirectories, the repositories are not searched for other ones
+func findRepositories(path string) (repos []string, err error) {
+	var info os.FileInfo
+	if info, err = os.Stat(path); err != nil {
+		return
+	}
+	if !info.IsDir() {
+		return readRepositories(path)
+	}
+	err = filepath.WalkDir(path, func(p string, d fs.DirEntry, err error) error {
+		if err != nil || !d.IsDir() {
+			return err
+		}
+		if _, err := os.Stat(filepath.Join(p, ".git")); err == nil {
+			repos = append(repos, p)
+			return filepath.SkipDir
+		}
+		return nil
+	})
+	if verbose {
+		logPrintf("-> %s\n", strings.Join(repos, ", "))
+	}
+	return
+}
+
+// the repositories of the list file, one in every line, relative to the file,
+// the empty lines and the ones starting with # are ignored
+func readRepositories(file string) (repos []string, err error) {
+	var f *os.File
+	if f, err = os.Open(file); err != nil {
+		return
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		l := strings.TrimSpace(scanner.Text())
+		if l == "" || strings.HasPrefix(l, "#") {
+			continue
+		}
+		if !filepath.IsAbs(l) {
+			l = filepath.Join(filepath.Dir(file), l)
+		}
+		repos = append(repos, l)
+	}
+	err = scanner.Err()
+	if verbose {
+		logPrintf("-> %s\n", strings.Join(repos, ", "))
+	}
+	return
+}
+
+// the options given to greb but the excluded ones, or their aliases, and the
+// arguments, to run greb in other repositories, the subcommand is run with its
+// own options
+func childArgs(args []string, exclude ...string) (child []string) {
+	visit := func(f *flag.Flag) {
+		for _, e := range exclude {
+			if f.Value == flag.Lookup(e).Value {
+				return
+			}
+		}
+		child = append(child, fmt.Sprintf("-%s=%s", f.Name, f.Value))
+	}
+	flag.Visit(visit)
+	if command != nil {
+		child = append(child, command.Name())
+		command.Visit(visit)
+	}
+	if len(args) > 0 {
+		child = append(append(child, "--"), args...)
+	}
+	return
+}
+
+// it runs greb in the repository writing everything into the buffer
+func runInRepository(repo, exe string, args []string) (output *bytes.Buffer,
+	err error) {
+	output = new(bytes.Buffer)
+	command, reset := getCommandColor(true)
+	fmt.Fprintf(output, "greb: %s%s%s\n", command, repo, reset)
+	cmd := newCommand(false, false, exe, args...)
+	cmd.Dir = repo
+	cmd.Stdout = output
+	cmd.Stderr = output
+	if err = cmd.Run(); err != nil {
+		err = cmdError(cmd, err)
+		// greb prints its own errors
+		var e *exec.ExitError
+		if !errors.As(err, &e) {
+			fmt.Fprintf(output, "greb: %s\n", err)
+		}
+	}
+	return
+}
+
+// a table with the outcome of every repository
+func formatWorkspace(repos []string, done []bool, errs []error) string {
+	var b bytes.Buffer
+	w := tabwriter.NewWriter(&b, 0, 8, 2, ' ', 0)
+	fmt.Fprintf(w, "repository\tresult\n")
+	for i, repo := range repos {
+		result := "ok"
+		if !done[i] {
+			result = "not run"
+		} else if errs[i] != nil {
+			result = "failed"
+		}
+		fmt.Fprintf(w, "%s\t%s\n", repo, result)
+	}
+	w.Flush()
+	return b.String()
+}
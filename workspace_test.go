@@ -0,0 +1,64 @@
+package main
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestFindRepositories(t *testing.T) {
+	dir := t.TempDir()
+	for _, d := range []string{"a/.git", "a/nested/.git", "b/c/.git", "d"} {
+		if err := os.MkdirAll(filepath.Join(dir, d), 0777); err != nil {
+			t.Fatal(err)
+		}
+	}
+	repos, err := findRepositories(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	e := []string{filepath.Join(dir, "a"), filepath.Join(dir, "b", "c")}
+	if !reflect.DeepEqual(repos, e) {
+		t.Error(repos)
+	}
+}
+
+func TestReadRepositories(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "repos")
+	if err := os.WriteFile(file, []byte("a\n\n# b\n  c/d  \n/e\n"), 0666); err != nil {
+		t.Fatal(err)
+	}
+	repos, err := findRepositories(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	e := []string{filepath.Join(dir, "a"), filepath.Join(dir, "c", "d"), "/e"}
+	if !reflect.DeepEqual(repos, e) {
+		t.Error(repos)
+	}
+}
+
+func TestChildArgs(t *testing.T) {
+	// the flags of greb and go test are not changed
+	oldflags, oldcommand := flag.CommandLine, command
+	defer func() {
+		flag.CommandLine, command = oldflags, oldcommand
+	}()
+	flag.CommandLine = flag.NewFlagSet("greb", flag.ContinueOnError)
+	command = nil
+	flag.Bool("q", false, "")
+	flag.String("workspace", "", "")
+	flag.Set("q", "true")
+	flag.Set("workspace", "dir")
+	args := childArgs([]string{"a", "b"}, "workspace")
+	if e := []string{"-q=true", "--", "a", "b"}; !reflect.DeepEqual(args, e) {
+		t.Error(args)
+	}
+	args = childArgs(nil, "q")
+	if e := []string{"-workspace=dir"}; !reflect.DeepEqual(args, e) {
+		t.Error(args)
+	}
+}